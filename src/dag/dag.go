@@ -5,6 +5,8 @@ package dag
 import (
 	"fmt"
 	"gotasker/src/graph"
+	"gotasker/src/workflow"
+	"sort"
 	"sync"
 )
 
@@ -14,9 +16,13 @@ type DAG struct {
 	reverse             bool
 	graph               *graph.DependencyGraph
 	dependencyTree      map[string][]string
+	triggers            map[string]string
 	toBeCanceled        map[string]struct{}
 	finishedTasksStatus map[string]map[string]struct{}
+	attempts            map[string]int
+	cleanupStatus       map[string]string
 	executionPlan       map[string]interface{}
+	observer            func(taskName string, status string)
 	mu                  sync.RWMutex
 }
 
@@ -27,10 +33,16 @@ func NewDAG(taskCollection []map[string]interface{}, reverse bool) *DAG {
 		taskCollection: taskCollection,
 		reverse:        reverse,
 		toBeCanceled:   make(map[string]struct{}),
+		attempts:       make(map[string]int),
+		cleanupStatus:  make(map[string]string),
+		triggers:       make(map[string]string),
 		finishedTasksStatus: map[string]map[string]struct{}{
 			"failed":     {},
 			"canceled":   {},
 			"successful": {},
+			"timed-out":  {},
+			"skipped":    {},
+			"up-to-date": {},
 		},
 	}
 	d.graph, d.dependencyTree = d.buildDAG()
@@ -53,47 +65,182 @@ func (d *DAG) GetExecutionPlan() map[string]interface{} {
 	return d.executionPlan
 }
 
+// Dependencies returns the tasks the given task depends on, directly or
+// through other tasks, sorted by name. In a reversed DAG these are its
+// dependents.
+func (d *DAG) Dependencies(taskName string) []string {
+	dependencies := make([]string, 0)
+	for dependency := range d.graph.Dependencies(taskName) {
+		dependencies = append(dependencies, dependency)
+	}
+	sort.Strings(dependencies)
+	return dependencies
+}
+
 // GetTopSortedLayers returns tasks grouped in layers for parallel execution.
 // Each layer contains tasks that can be run concurrently.
 func (d *DAG) GetTopSortedLayers() [][]string {
 	return d.graph.TopSortedLayers()
 }
 
-// SetStatus sets the status of a given task.
+// SetStatus sets the status of a given task and reports it to the status
+// observer, if any.
 func (d *DAG) SetStatus(taskName string, status string) {
 	d.mu.Lock()
-	defer d.mu.Unlock()
 	d.finishedTasksStatus[status][taskName] = struct{}{}
+	observer := d.observer
+	d.mu.Unlock()
+	if observer != nil {
+		observer(taskName, status)
+	}
+}
+
+// OnStatusChange sets a function called after every SetStatus, outside of
+// the DAG lock, for instance to checkpoint the run.
+func (d *DAG) OnStatusChange(observer func(taskName string, status string)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.observer = observer
 }
 
 // GetStatus returns the status of a given task.
 func (d *DAG) GetStatus(taskName string) string {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
+	return d.getStatusLocked(taskName)
+}
+
+// getStatusLocked returns the status of a given task.
+// Must be called with d.mu held.
+func (d *DAG) getStatusLocked(taskName string) string {
 	if _, ok := d.finishedTasksStatus["successful"][taskName]; ok {
 		return "successful"
 	} else if _, ok := d.finishedTasksStatus["failed"][taskName]; ok {
 		return "failed"
 	} else if _, ok := d.finishedTasksStatus["canceled"][taskName]; ok {
 		return "canceled"
+	} else if _, ok := d.finishedTasksStatus["timed-out"][taskName]; ok {
+		return "timed-out"
+	} else if _, ok := d.finishedTasksStatus["skipped"][taskName]; ok {
+		return "skipped"
+	} else if _, ok := d.finishedTasksStatus["up-to-date"][taskName]; ok {
+		return "up-to-date"
 	}
 	return "pending"
 }
 
+// SetAttempts records how many times a task has been attempted.
+func (d *DAG) SetAttempts(taskName string, attempts int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.attempts[taskName] = attempts
+}
+
+// GetAttempts returns how many times a task has been attempted.
+func (d *DAG) GetAttempts(taskName string) int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.attempts[taskName]
+}
+
+// SetCleanupStatus records the outcome of the cleanup action of a task.
+func (d *DAG) SetCleanupStatus(taskName string, status string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cleanupStatus[taskName] = status
+}
+
+// GetCleanupStatus returns the outcome of the cleanup action of a task,
+// or an empty string if no cleanup ran.
+func (d *DAG) GetCleanupStatus(taskName string) string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.cleanupStatus[taskName]
+}
+
+// IsReady reports whether the given task can be launched according to its
+// trigger rule: once every dependency has reached a final status, as soon as
+// one has failed for one-failed, and right away for always.
+func (d *DAG) IsReady(taskName string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	statuses := d.parentStatusesLocked(taskName)
+	switch d.triggers[taskName] {
+	case workflow.TriggerAlways:
+		return true
+	case workflow.TriggerOneFailed:
+		for _, status := range statuses {
+			if isFailure(status) {
+				return true
+			}
+		}
+	}
+	for _, status := range statuses {
+		if status == "pending" {
+			return false
+		}
+	}
+	return true
+}
+
+// ParentStatuses returns the status of each dependency of the given task.
+func (d *DAG) ParentStatuses(taskName string) map[string]string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.parentStatusesLocked(taskName)
+}
+
+// parentStatusesLocked returns the status of each dependency of a task.
+// Must be called with d.mu held.
+func (d *DAG) parentStatusesLocked(taskName string) map[string]string {
+	statuses := make(map[string]string, len(d.dependencyTree[taskName]))
+	for _, dependency := range d.dependencyTree[taskName] {
+		statuses[dependency] = d.getStatusLocked(dependency)
+	}
+	return statuses
+}
+
+// GetTrigger returns the trigger rule of a task, or an empty string for the
+// default.
+func (d *DAG) GetTrigger(taskName string) string {
+	return d.triggers[taskName]
+}
+
+// TriggerSatisfied reports whether the parent statuses of a ready task meet
+// its trigger rule. Tasks without an explicit rule are always satisfied, the
+// cancel policies having already canceled them if needed.
+func (d *DAG) TriggerSatisfied(taskName string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	statuses := d.parentStatusesLocked(taskName)
+	switch d.triggers[taskName] {
+	case workflow.TriggerAllSuccess:
+		for _, status := range statuses {
+			if status != "successful" && status != "skipped" && status != "up-to-date" {
+				return false
+			}
+		}
+	case workflow.TriggerOneFailed:
+		for _, status := range statuses {
+			if isFailure(status) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// isFailure reports whether a status means the task failed.
+func isFailure(status string) bool {
+	return status == "failed" || status == "timed-out"
+}
+
 // CancelTask marks a task for cancellation if it is still pending.
 func (d *DAG) CancelTask(taskName string) bool {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	// Check status without lock (we already hold it)
-	pending := true
-	if _, ok := d.finishedTasksStatus["successful"][taskName]; ok {
-		pending = false
-	} else if _, ok := d.finishedTasksStatus["failed"][taskName]; ok {
-		pending = false
-	} else if _, ok := d.finishedTasksStatus["canceled"][taskName]; ok {
-		pending = false
-	}
-	if pending {
+	if d.getStatusLocked(taskName) == "pending" {
 		d.toBeCanceled[taskName] = struct{}{}
 		return true
 	}
@@ -159,6 +306,9 @@ func (d *DAG) buildDAG() (*graph.DependencyGraph, map[string][]string) {
 				fmt.Printf("Warning: could not add dependency %s -> %s: %v\n", taskName, dependency, err)
 			}
 		}
+		if trigger, ok := task["trigger"].(string); ok && trigger != "" {
+			d.triggers[taskName] = trigger
+		}
 		// Ensure standalone tasks (no dependencies) are still in the graph
 		g.AddNode(taskName)
 		dependencyDict[taskName] = dependencies
@@ -178,7 +328,7 @@ func getAllTaskSet(tasks map[string]struct{}) map[string]struct{} {
 // cancelDependantTasksLocked cancels dependent tasks based on the given cancel policy.
 // Must be called with d.mu held.
 func (d *DAG) cancelDependantTasksLocked(taskName string, cancelPolicy string) {
-	if cancelPolicy == "continue" {
+	if cancelPolicy == workflow.PolicyContinue {
 		return
 	}
 	notCancelledTasks := make(map[string]struct{})
@@ -188,14 +338,37 @@ func (d *DAG) cancelDependantTasksLocked(taskName string, cancelPolicy string) {
 	for k, v := range d.finishedTasksStatus["successful"] {
 		notCancelledTasks[k] = v
 	}
+	for k, v := range d.finishedTasksStatus["timed-out"] {
+		notCancelledTasks[k] = v
+	}
+	for k, v := range d.finishedTasksStatus["skipped"] {
+		notCancelledTasks[k] = v
+	}
+	for k, v := range d.finishedTasksStatus["up-to-date"] {
+		notCancelledTasks[k] = v
+	}
 
-	if cancelPolicy == "abort-all" {
+	// Tasks with their own trigger rule decide for themselves whether they
+	// run after a failure.
+	for name, trigger := range d.triggers {
+		if trigger != workflow.TriggerAllSuccess {
+			notCancelledTasks[name] = struct{}{}
+		}
+	}
+
+	if cancelPolicy == workflow.PolicyAbortAll {
 		// Cancel every task in the execution plan
+		allTasks := make(map[string]struct{})
 		for rootTask, subtree := range d.executionPlan {
-			d.toBeCanceled[rootTask] = struct{}{}
-			collectTaskNames(subtree, d.toBeCanceled)
+			allTasks[rootTask] = struct{}{}
+			collectTaskNames(subtree, allTasks)
+		}
+		for k := range allTasks {
+			if _, exempt := notCancelledTasks[k]; !exempt {
+				d.toBeCanceled[k] = struct{}{}
+			}
 		}
-	} else if cancelPolicy == "abort-related-flows" {
+	} else if cancelPolicy == workflow.PolicyAbortRelatedFlows {
 		// Cancel the root task and its subtree if the failed task is part of it
 		for rootTask, subtree := range d.executionPlan {
 			allTasks := map[string]struct{}{rootTask: {}}
@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"gotasker/src/runner"
+	"gotasker/src/state"
+)
+
+// recordStatus writes a status transition to the checkpoint, with the
+// outputs of successful and up to date tasks so resumed runs can still read
+// them.
+func (w *Engine) recordStatus(taskName string, status string) {
+	var outputs map[string]string
+	if result := w.GetResult(taskName); result != nil && (status == "successful" || status == "up-to-date") {
+		outputs = result.Outputs
+	}
+	if err := w.Checkpoint.Record(taskName, status, outputs); err != nil {
+		w.logf("Warning: cannot checkpoint task %s: %v\n", taskName, err)
+	}
+}
+
+// Resume marks the tasks a previous run completed as done, so Run only
+// executes what remains: tasks that succeeded, were up to date or were
+// skipped keep their status, the other ones run again. With rerunFailed only the tasks that
+// failed, timed out or were canceled run again; the ones the previous run
+// never reached are skipped. It returns the number of tasks left to run.
+func (w *Engine) Resume(previous state.Checkpoint, rerunFailed bool) int {
+	remaining := 0
+	for _, task := range w.TaskCollection {
+		recorded, ok := previous.Tasks[task.Name]
+		switch {
+		case ok && (recorded.Status == "successful" || recorded.Status == "up-to-date" || recorded.Status == "skipped"):
+			w.setResult(task.Name, &runner.Result{Outputs: recorded.Outputs})
+			w.DAG.SetStatus(task.Name, recorded.Status)
+			w.resumed[task.Name] = struct{}{}
+		case rerunFailed && !ok:
+			w.DAG.SetStatus(task.Name, "skipped")
+			w.resumed[task.Name] = struct{}{}
+		default:
+			remaining++
+		}
+	}
+	return remaining
+}
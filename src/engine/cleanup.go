@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"context"
+	"gotasker/src/runner"
+	"gotasker/src/workflow"
+)
+
+// runCleanup runs the cleanup action of a task whose do action finished with
+// the given status, if its cleanup-when setting asks for it. The cleanup is
+// not tied to ctx so it still runs when the workflow is aborted, but it keeps
+// the kill options of ctx and the task timeout. Its outcome is recorded in
+// the DAG separately from the task status.
+func (w *Engine) runCleanup(ctx context.Context, task *workflow.Task, status string) {
+	if !task.Cleanup.IsSet() || !cleanupApplies(task.CleanupWhen, status) {
+		return
+	}
+	w.logf("Running cleanup of task: %s\n", task.Name)
+
+	cleanupCtx := runner.WithKillOptions(context.Background(), runner.KillOptions{GracePeriod: w.GracePeriod, Force: w.force})
+	if timeout, err := workflow.ParseTimeout(task.Timeout); err == nil && timeout > 0 {
+		var cancel context.CancelFunc
+		cleanupCtx, cancel = context.WithTimeout(cleanupCtx, timeout)
+		defer cancel()
+	}
+
+	stdout, stderr, flush := w.taskOutput(task.Name + " cleanup")
+	_, err := w.runAction(runner.WithOutput(cleanupCtx, stdout, stderr), task.Cleanup, nil)
+	flush()
+	if err != nil {
+		w.logf("Cleanup of task %s failed: %v\n", task.Name, err)
+		w.DAG.SetCleanupStatus(task.Name, statusFor(err))
+		return
+	}
+	w.logf("Cleanup of task %s completed.\n", task.Name)
+	w.DAG.SetCleanupStatus(task.Name, "successful")
+}
+
+// cleanupApplies reports whether a cleanup with the given cleanup-when
+// setting must run after a task finished with the given status.
+func cleanupApplies(when string, status string) bool {
+	switch when {
+	case "", workflow.CleanupAlways:
+		return true
+	case workflow.CleanupOnSuccess:
+		return status == "successful"
+	case workflow.CleanupOnFailure:
+		return status == "failed" || status == "timed-out"
+	case workflow.CleanupOnCancel:
+		return status == "canceled"
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"fmt"
+	"gotasker/src/expr"
+	"gotasker/src/workflow"
+	"strings"
+)
+
+// triggerNotMet resolves a ready task whose trigger rule is not met: a
+// one-failed task is skipped and an all-success one canceled.
+func (w *Engine) triggerNotMet(taskName string) {
+	if w.DAG.GetTrigger(taskName) == workflow.TriggerOneFailed {
+		w.skipTask(taskName, "no dependency failed")
+		return
+	}
+	w.DAG.SetStatus(taskName, "canceled")
+	w.reportFailure(taskName, fmt.Errorf("a dependency did not succeed"))
+}
+
+// skippedDependency returns why a task is skipped because of its skipped
+// dependencies according to its skip-propagation rule, or "" if it is not.
+// Tasks with a trigger rule other than all-success are never skipped this
+// way.
+func (w *Engine) skippedDependency(task *workflow.Task) string {
+	if trigger := w.DAG.GetTrigger(task.Name); trigger != "" && trigger != workflow.TriggerAllSuccess {
+		return ""
+	}
+	dependencies := w.DAG.GetDependencyTree()[task.Name]
+	skipped := 0
+	for _, dependency := range dependencies {
+		if w.DAG.GetStatus(dependency) == "skipped" {
+			skipped++
+		}
+	}
+	if skipped == 0 {
+		return ""
+	}
+
+	rule := task.SkipPropagation
+	if rule == "" {
+		rule = w.SkipPropagation
+	}
+	switch rule {
+	case workflow.SkipPropagationNone:
+		return ""
+	case workflow.SkipPropagationAll:
+		if skipped < len(dependencies) {
+			return ""
+		}
+		return "all of its dependencies were skipped"
+	}
+	return "a dependency was skipped"
+}
+
+// checkCondition evaluates the when condition of a task, returning why the
+// task is skipped or "" if it runs.
+func (w *Engine) checkCondition(task *workflow.Task) (string, error) {
+	if task.When == "" {
+		return "", nil
+	}
+	ok, err := expr.Eval(task.When, w.templateData())
+	if err != nil {
+		return "", fmt.Errorf("task %s: when condition %q: %w", task.Name, task.When, err)
+	}
+	if !ok {
+		return fmt.Sprintf("condition %q is false", task.When), nil
+	}
+	return "", nil
+}
+
+// CheckCondition reports a when condition that does not parse. It is meant
+// for workflow.Options, so Validate reports such conditions.
+func CheckCondition(condition string) error {
+	_, err := expr.Parse(condition)
+	return err
+}
+
+// checkConditions reports the first when condition of the tasks that does
+// not parse. Conditions still holding templates are only complete at launch.
+func checkConditions(tasks []workflow.Task) error {
+	for _, task := range tasks {
+		if task.When == "" || strings.Contains(task.When, "{{") {
+			continue
+		}
+		if err := CheckCondition(task.When); err != nil {
+			return fmt.Errorf("task %s: invalid when condition: %w", task.Name, err)
+		}
+	}
+	return nil
+}
@@ -1,15 +1,23 @@
 // Package engine is responsible for starting and executing the workflow.
 // It uses the graph package to create a directed acyclic graph (DAG) and
-// execute tasks in parallel.
+// execute tasks in parallel as soon as their dependencies are satisfied.
 package engine
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"gotasker/src/dag"
 	"gotasker/src/runner"
+	"gotasker/src/state"
 	"gotasker/src/workflow"
+	"io"
+	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Engine is the main struct for the engine package. It contains the task collection and the DAG.
@@ -20,12 +28,111 @@ type Engine struct {
 	aborted        bool
 	mu             sync.Mutex
 	DryRun         bool
+	// Timeout bounds the whole workflow run. Zero means no limit.
+	Timeout time.Duration
+	// Policy is the cancel policy applied when a task without its own
+	// on-failure policy fails.
+	Policy string
+	// Output is the output mode, one of OutputPrefixed, OutputGrouped or
+	// OutputQuiet.
+	Output string
+	// Timestamps adds the time to every prefixed output line.
+	Timestamps bool
+	// Color colors the task name of every prefixed output line.
+	Color bool
+	outMu sync.Mutex
+	// SkipPropagation is the skip-propagation rule of the tasks that do not
+	// set their own.
+	SkipPropagation string
+	// Variables are the workflow variables, available to the templates
+	// rendered when a task is launched.
+	Variables map[string]interface{}
+	// VariableSources name where each variable comes from, for the dry run.
+	VariableSources map[string]string
+	// Env holds environment variables given to every action, below the
+	// action's own env.
+	Env map[string]string
+	// PluginDirs are searched for action plugins before PATH.
+	PluginDirs []string
+	// GracePeriod is how long running tasks get to exit after an abort or a
+	// timeout before they are killed.
+	GracePeriod time.Duration
+	cancel      context.CancelFunc
+	force       chan struct{}
+	forced      bool
+	// Checkpoint, if set, records every status transition of the run.
+	Checkpoint *state.Store
+	// resumed holds the tasks whose status was restored by Resume.
+	resumed map[string]struct{}
+	// results holds the result of each task that ran, was up to date or was
+	// restored by Resume. They are kept here rather than on the DAG, which
+	// would otherwise have to import runner for the result type.
+	results   map[string]*runner.Result
+	resultsMu sync.RWMutex
+	// Cache, if set, holds the fingerprints of the tasks declaring inputs or
+	// output files, which are skipped as up to date when unchanged.
+	Cache *state.Cache
+	// IgnoreCache runs every task even if it is up to date.
+	IgnoreCache bool
+	// File is the path of the workflow file, against which sub-workflow
+	// files are resolved.
+	File string
+	// Lenient loads sub-workflows with lenient templating, like the workflow
+	// of the engine was loaded.
+	Lenient bool
+	// parent is the engine running this one as a sub-workflow, if any.
+	parent *Engine
+	// stdout and stderr replace os.Stdout and os.Stderr when set.
+	stdout io.Writer
+	stderr io.Writer
 }
 
 // NewEngine creates a new Engine with the given task collection.
 func NewEngine(wf *workflow.Workflow, threads int, dryRun bool) (*Engine, error) {
-	var tasks []map[string]interface{}
 	wfTasks := wf.Tasks
+	if err := checkConditions(wfTasks); err != nil {
+		return nil, err
+	}
+	taskDAG, err := newDAG(wfTasks)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := workflow.ParseTimeout(wf.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing workflow timeout: %w", err)
+	}
+	variables, _ := wf.Variables.(map[string]interface{})
+	policy := wf.OnFailure
+	if policy == "" {
+		policy = workflow.PolicyAbortRelatedFlows
+	}
+	if !workflow.IsValidCancelPolicy(policy) {
+		return nil, fmt.Errorf("unknown on-failure policy %q", policy)
+	}
+	return &Engine{
+		TaskCollection:  wfTasks,
+		DAG:             taskDAG,
+		Threads:         threads,
+		DryRun:          dryRun,
+		Timeout:         timeout,
+		Policy:          policy,
+		PluginDirs:      wf.Plugins,
+		File:            wf.File,
+		Variables:       variables,
+		VariableSources: wf.VariableSources,
+		SkipPropagation: wf.SkipPropagation,
+		Env:             workflowEnv(wf.Env),
+		Output:          OutputPrefixed,
+		GracePeriod:     runner.DefaultGracePeriod,
+		force:           make(chan struct{}),
+		resumed:         make(map[string]struct{}),
+		results:         make(map[string]*runner.Result),
+	}, nil
+}
+
+// newDAG builds the DAG of a task collection.
+func newDAG(wfTasks []workflow.Task) (*dag.DAG, error) {
+	var tasks []map[string]interface{}
 	jsonTasks, err := json.Marshal(&wfTasks)
 	if err != nil {
 		return nil, fmt.Errorf("error marshalling tasks: %w", err)
@@ -34,12 +141,7 @@ func NewEngine(wf *workflow.Workflow, threads int, dryRun bool) (*Engine, error)
 	if err != nil {
 		return nil, fmt.Errorf("error unmarshalling tasks: %w", err)
 	}
-	return &Engine{
-		TaskCollection: wfTasks,
-		DAG:            dag.NewDAG(tasks, false),
-		Threads:        threads,
-		DryRun:         dryRun,
-	}, nil
+	return dag.NewDAG(tasks, false), nil
 }
 
 // getTaskByName finds a task in the task collection by its name.
@@ -52,32 +154,235 @@ func (w *Engine) getTaskByName(name string) *workflow.Task {
 	return nil
 }
 
-// ExecuteTask executes a single task and returns its output or an error.
-func (w *Engine) ExecuteTask(task *workflow.Task) (string, error) {
-	fmt.Printf("Executing task: %s\n", task.Name)
+// ExecuteTask executes a single task and returns the result of its last
+// attempt and an error if any. The result is also recorded in the engine.
+func (w *Engine) ExecuteTask(task *workflow.Task) (*runner.Result, error) {
+	task, err := w.renderTask(task)
+	if err != nil {
+		return nil, err
+	}
+	return w.executeTask(context.Background(), task)
+}
+
+// setResult records the execution result of a task.
+func (w *Engine) setResult(taskName string, result *runner.Result) {
+	w.resultsMu.Lock()
+	defer w.resultsMu.Unlock()
+	w.results[taskName] = result
+}
 
-	// Build the action params map for the runner
-	params := map[string]interface{}{
-		"path": task.Do.With.Path,
-		"args": func() []interface{} {
-			args := make([]interface{}, len(task.Do.With.Args))
-			copy(args, task.Do.With.Args)
-			return args
-		}(),
+// GetResult returns the execution result of a task, or nil if the task did
+// not run.
+func (w *Engine) GetResult(taskName string) *runner.Result {
+	w.resultsMu.RLock()
+	defer w.resultsMu.RUnlock()
+	return w.results[taskName]
+}
+
+// executeTask executes a single task, retrying it according to its retry
+// policy. Each attempt is killed when the task timeout or ctx expires; a
+// timeout is reported as an error wrapping context.DeadlineExceeded. The
+// result of the last attempt is recorded in the engine.
+func (w *Engine) executeTask(ctx context.Context, task *workflow.Task) (*runner.Result, error) {
+	policy, err := task.RetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("task %s has an invalid retry policy: %w", task.Name, err)
 	}
 
-	execution := runner.NewExecution(task.Do.With.Path, params)
-	output, err := execution.Execute()
+	for attempt := 1; ; attempt++ {
+		result, err := w.executeAttempt(ctx, task)
+		w.DAG.SetAttempts(task.Name, attempt)
+		if result != nil {
+			w.setResult(task.Name, result)
+		}
+		if err == nil || attempt > policy.Retries || ctx.Err() != nil || w.isAborted() {
+			return result, err
+		}
+		if result == nil || !policy.ShouldRetry(result.ExitCode, result.Output()) {
+			return result, err
+		}
+
+		delay := policy.Backoff(attempt)
+		w.logf("Task %s failed (attempt %d/%d), retrying in %s: %v\n", task.Name, attempt, policy.Retries+1, delay, err)
+		select {
+		case <-ctx.Done():
+			return result, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// executeAttempt runs a task once, killing it when its timeout or ctx expires.
+// On success the outputs of the task are captured into the result.
+func (w *Engine) executeAttempt(ctx context.Context, task *workflow.Task) (*runner.Result, error) {
+	w.logf("Executing task: %s\n", task.Name)
+
+	timeout, err := workflow.ParseTimeout(task.Timeout)
 	if err != nil {
-		return "", fmt.Errorf("task %s failed: %w", task.Name, err)
+		return nil, fmt.Errorf("task %s has an invalid timeout: %w", task.Name, err)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
 
-	fmt.Printf("Task %s completed. Output: %s", task.Name, output)
-	return output, nil
+	outputFile, err := newOutputFile()
+	if err != nil {
+		return nil, fmt.Errorf("task %s: %w", task.Name, err)
+	}
+	defer os.Remove(outputFile)
+
+	stdout, stderr, flush := w.taskOutput(task.Name)
+	env := map[string]string{workflow.OutputFileEnv: outputFile}
+	result, err := w.runAction(runner.WithOutput(ctx, stdout, stderr), task.Do, env)
+	flush()
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return result, fmt.Errorf("task %s timed out: %w", task.Name, err)
+		}
+		return result, fmt.Errorf("task %s failed: %w", task.Name, err)
+	}
+	if err := captureOutputs(task, result, outputFile); err != nil {
+		return result, fmt.Errorf("task %s failed: %w", task.Name, err)
+	}
+
+	w.logf("Task %s completed.\n", task.Name)
+	return result, nil
+}
+
+// CheckAction reports an action that is neither registered nor found as a
+// plugin in pluginDirs. It is meant for workflow.Options, so Validate reports
+// such actions.
+func CheckAction(action string, pluginDirs []string) error {
+	_, err := runner.Resolve(action, pluginDirs)
+	return err
+}
+
+// actionName returns the registered action an action refers to.
+func actionName(action workflow.Action) string {
+	if action.This == "" {
+		return runner.DefaultAction
+	}
+	return action.This
+}
+
+// workflowEnv converts the values of the workflow env to strings.
+func workflowEnv(env map[string]interface{}) map[string]string {
+	converted := make(map[string]string, len(env))
+	for k, v := range env {
+		converted[k] = fmt.Sprint(v)
+	}
+	return converted
+}
+
+// actionParams builds the params map handed to the action's runner, adding
+// the engine env as workflow-env, which runners apply below the action's
+// env-file and env, and the given env above the action's env.
+func (w *Engine) actionParams(action workflow.Action, env map[string]string) map[string]interface{} {
+	params := action.With.Map()
+	params["path"] = action.With.Path
+	params["args"] = func() []interface{} {
+		args := make([]interface{}, len(action.With.Args))
+		copy(args, action.With.Args)
+		return args
+	}()
+	if len(w.Env) > 0 {
+		workflowEnv := make(map[string]interface{}, len(w.Env))
+		for k, v := range w.Env {
+			workflowEnv[k] = v
+		}
+		params["workflow-env"] = workflowEnv
+	}
+	if len(env) > 0 {
+		merged := make(map[string]interface{}, len(action.With.Env)+len(env))
+		for k, v := range action.With.Env {
+			merged[k] = v
+		}
+		for k, v := range env {
+			merged[k] = v
+		}
+		params["env"] = merged
+	}
+	return params
+}
+
+// runAction builds the runner for an action, either a registered one or a
+// plugin, and executes it with the given extra environment variables.
+func (w *Engine) runAction(ctx context.Context, action workflow.Action, env map[string]string) (*runner.Result, error) {
+	factory, err := runner.Resolve(actionName(action), w.PluginDirs)
+	if err != nil {
+		return nil, err
+	}
+	r, err := factory(w.actionParams(action, env))
+	if err != nil {
+		return nil, err
+	}
+	return r.ExecuteContext(context.WithValue(ctx, parentKey{}, w))
+}
+
+// Validate checks the output mode and that every task refers to a registered
+// action or to an action plugin. Run calls it before starting any task, so actions registered
+// with runner.Register after NewEngine are taken into account.
+func (w *Engine) Validate() error {
+	if !IsValidOutputMode(w.Output) {
+		return fmt.Errorf("unknown output mode %q", w.Output)
+	}
+	for _, task := range w.TaskCollection {
+		if _, err := runner.Resolve(actionName(task.Do), w.PluginDirs); err != nil {
+			return fmt.Errorf("task %s: %w (registered: %s)", task.Name, err, strings.Join(runner.Actions(), ", "))
+		}
+		if task.Cleanup.IsSet() {
+			if _, err := runner.Resolve(actionName(task.Cleanup), w.PluginDirs); err != nil {
+				return fmt.Errorf("task %s cleanup: %w (registered: %s)", task.Name, err, strings.Join(runner.Actions(), ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// isAborted reports whether the execution has been aborted.
+func (w *Engine) isAborted() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.aborted
+}
+
+// taskResult carries the outcome of a task launched by the scheduler.
+type taskResult struct {
+	name string
+	err  error
+	// upToDate is set for tasks skipped because nothing changed.
+	upToDate bool
+}
+
+// prepareTask returns the task to be launched. If the task must not run, it
+// returns the status to record for it and the reason.
+func (w *Engine) prepareTask(ctx context.Context, taskName string) (*workflow.Task, string, error) {
+	if w.isAborted() {
+		return nil, "canceled", fmt.Errorf("execution aborted")
+	}
+	if ctx.Err() != nil {
+		return nil, "canceled", fmt.Errorf("workflow timed out")
+	}
+
+	// Check if the task is marked for cancellation
+	if _, ok := w.DAG.GetTasksToCancel()[taskName]; ok {
+		return nil, "canceled", fmt.Errorf("task canceled")
+	}
+
+	task := w.getTaskByName(taskName)
+	if task == nil {
+		return nil, "failed", fmt.Errorf("task %s not found in collection", taskName)
+	}
+	return task, "", nil
 }
 
 // ExecuteTaskLayerParallel executes all tasks in a layer in parallel,
 // limited by the configured number of threads.
+//
+// Deprecated: Run no longer executes the DAG layer by layer; it launches
+// each task as soon as its dependencies have finished. Use Run instead.
 func (w *Engine) ExecuteTaskLayerParallel(layer []string) map[string]error {
 	results := make(map[string]error)
 	var mu sync.Mutex
@@ -87,33 +392,12 @@ func (w *Engine) ExecuteTaskLayerParallel(layer []string) map[string]error {
 	sem := make(chan struct{}, w.Threads)
 
 	for _, taskName := range layer {
-		// Check if we should abort
-		w.mu.Lock()
-		if w.aborted {
-			w.mu.Unlock()
-			mu.Lock()
-			results[taskName] = fmt.Errorf("execution aborted")
-			mu.Unlock()
-			w.DAG.SetStatus(taskName, "canceled")
-			continue
-		}
-		w.mu.Unlock()
-
-		// Check if the task is marked for cancellation
-		if _, ok := w.DAG.GetTasksToCancel()[taskName]; ok {
-			mu.Lock()
-			results[taskName] = fmt.Errorf("task canceled")
-			mu.Unlock()
-			w.DAG.SetStatus(taskName, "canceled")
-			continue
-		}
-
-		task := w.getTaskByName(taskName)
+		task, status, err := w.prepareTask(context.Background(), taskName)
 		if task == nil {
 			mu.Lock()
-			results[taskName] = fmt.Errorf("task %s not found in collection", taskName)
+			results[taskName] = err
 			mu.Unlock()
-			w.DAG.SetStatus(taskName, "failed")
+			w.DAG.SetStatus(taskName, status)
 			continue
 		}
 
@@ -124,15 +408,12 @@ func (w *Engine) ExecuteTaskLayerParallel(layer []string) map[string]error {
 			defer func() { <-sem }() // Release semaphore slot
 
 			_, err := w.ExecuteTask(t)
+			w.runCleanup(context.Background(), t, statusFor(err))
 			mu.Lock()
 			results[name] = err
 			mu.Unlock()
 
-			if err != nil {
-				w.DAG.SetStatus(name, "failed")
-			} else {
-				w.DAG.SetStatus(name, "successful")
-			}
+			w.DAG.SetStatus(name, statusFor(err))
 		}(task, taskName)
 	}
 
@@ -140,9 +421,17 @@ func (w *Engine) ExecuteTaskLayerParallel(layer []string) map[string]error {
 	return results
 }
 
-// Run starts the workflow execution. It processes layers from the DAG
-// topological sort and executes each layer in parallel.
+// Run starts the workflow execution. Each task is launched as soon as all of
+// its dependencies have finished, keeping at most Threads tasks running,
+// which must be at least 1.
 func (w *Engine) Run() error {
+	if w.Threads < 1 {
+		return fmt.Errorf("invalid number of threads %d: must be at least 1", w.Threads)
+	}
+	if err := w.Validate(); err != nil {
+		return err
+	}
+
 	if w.DryRun {
 		w.PrintExecutionPlan()
 		return nil
@@ -152,72 +441,239 @@ func (w *Engine) Run() error {
 	w.mu.Lock()
 	if w.aborted {
 		w.mu.Unlock()
-		fmt.Println("Execution aborted.")
+		fmt.Fprintln(w.out(), "Execution aborted.")
 		return fmt.Errorf("execution aborted")
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
 	w.mu.Unlock()
+	defer cancel()
 
-	layers := w.DAG.GetTopSortedLayers()
+	if w.Timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, w.Timeout)
+		defer cancelTimeout()
+	}
+	ctx = runner.WithKillOptions(ctx, runner.KillOptions{GracePeriod: w.GracePeriod, Force: w.force})
 
-	for i, layer := range layers {
-		// Check abort
-		w.mu.Lock()
-		if w.aborted {
-			w.mu.Unlock()
-			fmt.Println("Execution aborted.")
-			return fmt.Errorf("execution aborted")
+	if w.Checkpoint != nil {
+		w.DAG.OnStatusChange(w.recordStatus)
+		defer w.DAG.OnStatusChange(nil)
+	}
+
+	order := w.DAG.GetAvailableTasks()
+	started := make(map[string]struct{}, len(order))
+	results := make(chan taskResult)
+	running := 0
+
+	for {
+		running += w.launchReadyTasks(ctx, order, started, running, results)
+		if running == 0 {
+			break
 		}
-		w.mu.Unlock()
+		result := <-results
+		running--
+		if result.upToDate {
+			w.DAG.SetStatus(result.name, "up-to-date")
+			continue
+		}
+		w.finishTask(result.name, result.err)
+	}
 
-		fmt.Printf("--- Layer %d: %v ---\n", i+1, layer)
+	// Sub-workflows report their tasks in the summary of their parent.
+	if w.parent == nil {
+		w.printSummary()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.aborted {
+		return fmt.Errorf("execution aborted")
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("workflow timed out after %s", w.Timeout)
+	}
+	return nil
+}
 
-		results := w.ExecuteTaskLayerParallel(layer)
+// launchReadyTasks starts every task whose dependencies have finished, in
+// topological order, without exceeding the thread limit. Tasks that must not
+// run are resolved on the spot, which may in turn make other tasks ready.
+// It returns the number of tasks started.
+func (w *Engine) launchReadyTasks(ctx context.Context, order []string, started map[string]struct{}, running int, results chan<- taskResult) int {
+	launched := 0
+	for progressed := true; progressed; {
+		progressed = false
+		for _, taskName := range order {
+			if running+launched >= w.Threads {
+				return launched
+			}
+			if _, ok := started[taskName]; ok || !w.DAG.IsReady(taskName) {
+				continue
+			}
+			// Tasks restored by Resume already have their final status.
+			if w.DAG.GetStatus(taskName) != "pending" {
+				started[taskName] = struct{}{}
+				continue
+			}
+			started[taskName] = struct{}{}
+			progressed = true
 
-		// Check for failures and handle cancel policies
-		for taskName, err := range results {
-			if err != nil {
-				fmt.Printf("Task %s failed: %v\n", taskName, err)
-				// Cancel dependent tasks using abort-related-flows policy
-				w.DAG.CancelDependentTasks(taskName, "abort-related-flows")
+			task, status, err := w.prepareTask(ctx, taskName)
+			if task == nil {
+				w.DAG.SetStatus(taskName, status)
+				w.reportFailure(taskName, err)
+				continue
+			}
+			if !w.DAG.TriggerSatisfied(taskName) {
+				w.triggerNotMet(taskName)
+				continue
 			}
+			if reason := w.skippedDependency(task); reason != "" {
+				w.skipTask(taskName, reason)
+				continue
+			}
+			if task, err = w.renderTask(task); err != nil {
+				w.DAG.SetStatus(taskName, "failed")
+				w.reportFailure(taskName, err)
+				continue
+			}
+			if reason, err := w.checkCondition(task); err != nil {
+				w.DAG.SetStatus(taskName, "failed")
+				w.reportFailure(taskName, err)
+				continue
+			} else if reason != "" {
+				w.skipTask(taskName, reason)
+				continue
+			}
+
+			launched++
+			go func(t *workflow.Task) {
+				fingerprint, upToDate := w.checkUpToDate(t)
+				if upToDate {
+					results <- taskResult{name: t.Name, upToDate: true}
+					return
+				}
+				result, err := w.executeTask(ctx, t)
+				w.recordFingerprint(t, fingerprint, result, err)
+				w.runCleanup(ctx, t, statusFor(err))
+				results <- taskResult{name: t.Name, err: err}
+			}(task)
 		}
 	}
+	return launched
+}
 
-	// Print summary
-	fmt.Println("\n=== Execution Summary ===")
-	for _, task := range w.TaskCollection {
-		status := w.DAG.GetStatus(task.Name)
-		fmt.Printf("  %s: %s\n", task.Name, status)
+// skipTask marks a task as skipped without running it.
+func (w *Engine) skipTask(taskName string, reason string) {
+	w.logf("Task %s skipped: %s\n", taskName, reason)
+	w.DAG.SetStatus(taskName, "skipped")
+}
+
+// finishTask records the outcome of a task that has finished running.
+func (w *Engine) finishTask(taskName string, err error) {
+	w.DAG.SetStatus(taskName, statusFor(err))
+	if err != nil {
+		w.reportFailure(taskName, err)
 	}
+}
 
-	return nil
+// statusFor maps the error returned by a task execution to its DAG status.
+func statusFor(err error) string {
+	switch {
+	case err == nil:
+		return "successful"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timed-out"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "failed"
+	}
+}
+
+// reportFailure prints a task failure and cancels other tasks according to
+// the task's on-failure policy, falling back to the engine policy.
+func (w *Engine) reportFailure(taskName string, err error) {
+	w.logf("Task %s failed: %v\n", taskName, err)
+	w.DAG.CancelDependentTasks(taskName, w.policyFor(taskName))
+}
+
+// policyFor returns the cancel policy that applies when the given task fails.
+func (w *Engine) policyFor(taskName string) string {
+	if task := w.getTaskByName(taskName); task != nil && task.OnFailure != "" {
+		return task.OnFailure
+	}
+	return w.Policy
 }
 
 // PrintExecutionPlan prints the execution plan without running tasks.
 func (w *Engine) PrintExecutionPlan() {
-	fmt.Println("=== Execution Plan (Dry Run) ===")
+	out := w.out()
+	fmt.Fprintln(out, "=== Execution Plan (Dry Run) ===")
+	w.printVariables()
 	layers := w.DAG.GetTopSortedLayers()
 	for i, layer := range layers {
-		fmt.Printf("Layer %d:\n", i+1)
+		fmt.Fprintf(out, "Layer %d:\n", i+1)
 		for _, taskName := range layer {
 			task := w.getTaskByName(taskName)
 			if task != nil {
-				fmt.Printf("  - %s: %s %v\n", task.Name, task.Do.With.Path, task.Do.With.Args)
+				fmt.Fprintf(out, "  - %s: %s %v\n", task.Name, task.Do.With.Path, task.Do.With.Args)
 			} else {
-				fmt.Printf("  - %s: (not found)\n", taskName)
+				fmt.Fprintf(out, "  - %s: (not found)\n", taskName)
 			}
 		}
 	}
 }
 
-// AbortExecution aborts the execution of the workflow processor.
+// printVariables prints the resolved variables of the workflow with the
+// source of each one, sorted by name.
+func (w *Engine) printVariables() {
+	if len(w.Variables) == 0 {
+		return
+	}
+	names := make([]string, 0, len(w.Variables))
+	for name := range w.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := w.out()
+	fmt.Fprintln(out, "Variables:")
+	for _, name := range names {
+		value, err := json.Marshal(w.Variables[name])
+		if err != nil {
+			value = []byte(fmt.Sprint(w.Variables[name]))
+		}
+		if source := w.VariableSources[name]; source != "" {
+			fmt.Fprintf(out, "  %s = %s (%s)\n", name, value, source)
+		} else {
+			fmt.Fprintf(out, "  %s = %s\n", name, value)
+		}
+	}
+}
+
+// AbortExecution aborts the execution of the workflow processor. Pending tasks
+// are canceled and running ones are asked to terminate, getting GracePeriod to
+// exit before they are killed. Calling it again kills them right away.
 func (w *Engine) AbortExecution() {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	if w.aborted {
+		if !w.forced {
+			w.forced = true
+			close(w.force)
+			w.logf("Second abort signal received. Killing running tasks...\n")
+		}
+		return
+	}
 	w.aborted = true
 	// Cancel all pending tasks
 	for _, task := range w.TaskCollection {
 		w.DAG.CancelTask(task.Name)
 	}
-	fmt.Println("Abort signal received. Canceling pending tasks...")
+	// Terminate the running ones
+	if w.cancel != nil {
+		w.cancel()
+	}
+	w.logf("Abort signal received. Canceling pending tasks and terminating running ones...\n")
 }
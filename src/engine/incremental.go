@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gotasker/src/runner"
+	"gotasker/src/workflow"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// checkUpToDate computes the fingerprint of a task declaring inputs or
+// output files and reports whether it is up to date: the fingerprint matches
+// the one of its last successful run and its output files still exist. An up
+// to date task gets the outputs of that run. The fingerprint is empty for
+// tasks that are not cached.
+func (w *Engine) checkUpToDate(task *workflow.Task) (string, bool) {
+	if w.Cache == nil || !task.IsIncremental() {
+		return "", false
+	}
+	fingerprint, err := w.fingerprint(task)
+	if err != nil {
+		w.logf("Warning: cannot fingerprint task %s, running it: %v\n", task.Name, err)
+		return "", false
+	}
+	if w.IgnoreCache {
+		return fingerprint, false
+	}
+	previous, ok := w.Cache.Get(task.Name)
+	if !ok || previous.Hash != fingerprint {
+		return fingerprint, false
+	}
+	for _, path := range task.OutputPaths() {
+		if _, err := os.Stat(resolvePath(task.Do.With.Dir, path)); err != nil {
+			return fingerprint, false
+		}
+	}
+	w.logf("Task %s is up to date.\n", task.Name)
+	w.setResult(task.Name, &runner.Result{Outputs: previous.Outputs})
+	return fingerprint, true
+}
+
+// recordFingerprint caches the fingerprint of a task that succeeded, and
+// forgets the one of a task that did not so it runs again next time.
+func (w *Engine) recordFingerprint(task *workflow.Task, fingerprint string, result *runner.Result, err error) {
+	if w.Cache == nil || !task.IsIncremental() {
+		return
+	}
+	var cacheErr error
+	if err == nil && fingerprint != "" {
+		var outputs map[string]string
+		if result != nil {
+			outputs = result.Outputs
+		}
+		cacheErr = w.Cache.Put(task.Name, fingerprint, outputs)
+	} else {
+		cacheErr = w.Cache.Delete(task.Name)
+	}
+	if cacheErr != nil {
+		w.logf("Warning: cannot cache task %s: %v\n", task.Name, cacheErr)
+	}
+}
+
+// fingerprint hashes what decides the result of a task: its rendered action
+// with the environment it gets, the content of its env file, the inherited
+// variables it lists in inherit-env, and the content of its input files.
+func (w *Engine) fingerprint(task *workflow.Task) (string, error) {
+	sum := sha256.New()
+	action, err := json.Marshal(map[string]interface{}{
+		"this": task.Do.This,
+		"with": w.actionParams(task.Do, nil),
+	})
+	if err != nil {
+		return "", err
+	}
+	sum.Write(action)
+
+	dir := task.Do.With.Dir
+	if envFile := task.Do.With.EnvFile; envFile != "" {
+		if err := hashFile(sum, resolvePath(dir, envFile)); err != nil {
+			return "", err
+		}
+	}
+
+	inherited, err := workflow.ParseInheritEnv(task.Do.With.InheritEnv)
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, 0, len(inherited))
+	for name := range inherited {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(sum, "%s=%s\n", name, os.Getenv(name))
+	}
+
+	inputs, err := workflow.ExpandGlobs(dir, task.Inputs)
+	if err != nil {
+		return "", err
+	}
+	for _, input := range inputs {
+		fmt.Fprintf(sum, "%s\n", input)
+		if err := hashFile(sum, input); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// hashFile writes the hash of the content of a file to sum.
+func hashFile(sum hash.Hash, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	content := sha256.New()
+	if _, err := io.Copy(content, file); err != nil {
+		return err
+	}
+	sum.Write(content.Sum(nil))
+	return nil
+}
+
+// resolvePath resolves a relative path against the working directory of an
+// action, if it has one.
+func resolvePath(dir string, path string) string {
+	if dir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
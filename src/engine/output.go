@@ -0,0 +1,160 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Output modes deciding how the output of tasks is shown.
+const (
+	// OutputPrefixed streams each line as soon as it is produced, prefixed
+	// with the task name.
+	OutputPrefixed = "prefixed"
+	// OutputGrouped buffers the output of each task and prints it in one
+	// block once the task has finished.
+	OutputGrouped = "grouped"
+	// OutputQuiet hides the output of tasks.
+	OutputQuiet = "quiet"
+)
+
+// IsValidOutputMode reports whether the given name is a known output mode.
+func IsValidOutputMode(mode string) bool {
+	switch mode {
+	case OutputPrefixed, OutputGrouped, OutputQuiet:
+		return true
+	}
+	return false
+}
+
+// prefixColors are the ANSI colors used for task prefixes.
+var prefixColors = []string{"\033[36m", "\033[33m", "\033[32m", "\033[35m", "\033[34m", "\033[91m", "\033[96m", "\033[93m"}
+
+// colorReset is the ANSI sequence resetting the color.
+const colorReset = "\033[0m"
+
+// logf prints an engine message under the output lock, so it never lands in
+// the middle of a block of task output.
+func (w *Engine) logf(format string, args ...interface{}) {
+	w.outMu.Lock()
+	defer w.outMu.Unlock()
+	fmt.Fprintf(w.out(), format, args...)
+}
+
+// out returns the writer of engine messages and of the stdout of tasks.
+func (w *Engine) out() io.Writer {
+	if w.stdout != nil {
+		return w.stdout
+	}
+	return os.Stdout
+}
+
+// errOut returns the writer of the stderr of tasks.
+func (w *Engine) errOut() io.Writer {
+	if w.stderr != nil {
+		return w.stderr
+	}
+	return os.Stderr
+}
+
+// taskOutput returns the writers receiving the stdout and stderr of a task
+// according to the output mode, and a function to call once the task has
+// finished to print whatever is still buffered.
+func (w *Engine) taskOutput(name string) (io.Writer, io.Writer, func()) {
+	switch w.Output {
+	case OutputQuiet:
+		return io.Discard, io.Discard, func() {}
+	case OutputGrouped:
+		group := &groupWriter{}
+		return group, group, func() {
+			w.outMu.Lock()
+			defer w.outMu.Unlock()
+			if group.buf.Len() == 0 {
+				return
+			}
+			out := w.out()
+			fmt.Fprintf(out, "--- Output of %s ---\n", w.linePrefix(name))
+			out.Write(group.buf.Bytes())
+			if !bytes.HasSuffix(group.buf.Bytes(), []byte("\n")) {
+				fmt.Fprintln(out)
+			}
+		}
+	default:
+		stdout := &lineWriter{engine: w, task: name, dst: w.out()}
+		stderr := &lineWriter{engine: w, task: name, dst: w.errOut()}
+		return stdout, stderr, func() {
+			stdout.flush()
+			stderr.flush()
+		}
+	}
+}
+
+// linePrefix returns the task name, colored if colors are enabled.
+func (w *Engine) linePrefix(name string) string {
+	if !w.Color {
+		return name
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return prefixColors[h.Sum32()%uint32(len(prefixColors))] + name + colorReset
+}
+
+// groupWriter buffers the output of a task.
+type groupWriter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// Write appends p to the buffer.
+func (g *groupWriter) Write(p []byte) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.buf.Write(p)
+}
+
+// lineWriter writes every complete line it receives to dst, prefixed with the
+// task name and optionally a timestamp. Lines of concurrent tasks never
+// interleave because they are written under the engine output lock.
+type lineWriter struct {
+	engine  *Engine
+	task    string
+	dst     io.Writer
+	partial []byte
+}
+
+// Write prints the complete lines in p and keeps the trailing partial line.
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		l.printLine(l.partial[:i])
+		l.partial = l.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush prints the trailing partial line, if any.
+func (l *lineWriter) flush() {
+	if len(l.partial) > 0 {
+		l.printLine(l.partial)
+		l.partial = nil
+	}
+}
+
+// printLine prints a single line with its prefix.
+func (l *lineWriter) printLine(line []byte) {
+	prefix := "[" + l.engine.linePrefix(l.task) + "] "
+	if l.engine.Timestamps {
+		prefix = time.Now().Format("15:04:05.000") + " " + prefix
+	}
+	l.engine.outMu.Lock()
+	defer l.engine.outMu.Unlock()
+	fmt.Fprintf(l.dst, "%s%s\n", prefix, bytes.TrimSuffix(line, []byte("\r")))
+}
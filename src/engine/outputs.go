@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"fmt"
+	"gotasker/src/runner"
+	"gotasker/src/workflow"
+	"os"
+)
+
+// newOutputFile creates the empty file whose path a task receives in
+// $GOTASKER_OUTPUT.
+func newOutputFile() (string, error) {
+	file, err := os.CreateTemp("", "gotasker-output-*")
+	if err != nil {
+		return "", fmt.Errorf("error creating output file: %w", err)
+	}
+	return file.Name(), file.Close()
+}
+
+// captureOutputs adds to the outputs of a successful task the values it wrote
+// to its output file and the values it declares. Output files are not values
+// and are left out.
+func captureOutputs(task *workflow.Task, result *runner.Result, outputFile string) error {
+	fileValues, err := runner.ReadEnvFile(outputFile)
+	if err != nil {
+		return err
+	}
+	if result.Outputs == nil {
+		result.Outputs = make(map[string]string, len(fileValues)+len(task.Outputs))
+	}
+	for k, v := range fileValues {
+		result.Outputs[k] = v
+	}
+	for _, output := range task.Outputs {
+		if output.Path != "" {
+			continue
+		}
+		value, err := output.Extract(result.Stdout, fileValues)
+		if err != nil {
+			return err
+		}
+		result.Outputs[output.Name] = value
+	}
+	return nil
+}
+
+// renderTask renders the templates of a task that read the state of other
+// tasks. Tasks without such templates are returned as they are.
+func (w *Engine) renderTask(task *workflow.Task) (*workflow.Task, error) {
+	if !task.ReferencesTasks() {
+		return task, nil
+	}
+	rendered, err := workflow.RenderTask(*task, w.templateData())
+	if err != nil {
+		return nil, fmt.Errorf("task %s: %w", task.Name, err)
+	}
+	return &rendered, nil
+}
+
+// templateData returns the data tasks are rendered with at launch and their
+// conditions are evaluated with: the workflow variables, and under "tasks"
+// the status of every finished task along with the exit code and outputs of
+// the ones that ran.
+func (w *Engine) templateData() map[string]interface{} {
+	data := make(map[string]interface{}, len(w.Variables)+1)
+	for k, v := range w.Variables {
+		data[k] = v
+	}
+	tasks := make(map[string]interface{})
+	for _, task := range w.TaskCollection {
+		status := w.DAG.GetStatus(task.Name)
+		if status == "pending" {
+			continue
+		}
+		entry := map[string]interface{}{"status": status}
+		outputs := make(map[string]interface{})
+		if result := w.GetResult(task.Name); result != nil {
+			entry["exit-code"] = result.ExitCode
+			for k, v := range result.Outputs {
+				outputs[k] = v
+			}
+		}
+		entry["outputs"] = outputs
+		tasks[task.Name] = entry
+	}
+	data["tasks"] = tasks
+	return data
+}
@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"fmt"
+	"gotasker/src/workflow"
+)
+
+// Selection picks the tasks of a run.
+type Selection struct {
+	// Targets are the tasks to run. With Tags, they default to every task.
+	Targets []string
+	// Tags select the tasks having one of them, along with the targets.
+	Tags []string
+	// SkipTags remove the tasks having one of them, dependencies included.
+	SkipTags []string
+	// Only runs the selected tasks without their dependencies.
+	Only bool
+}
+
+// IsEmpty reports whether the selection keeps every task.
+func (s Selection) IsEmpty() bool {
+	return len(s.Targets) == 0 && len(s.Tags) == 0 && len(s.SkipTags) == 0
+}
+
+// Select restricts the run to the selected tasks and, unless Only is set,
+// the tasks they depend on. Dependencies on tasks left out are dropped, so
+// the remaining tasks run as if those had succeeded.
+func (w *Engine) Select(selection Selection) error {
+	if selection.IsEmpty() {
+		return nil
+	}
+	known := make(map[string]struct{}, len(w.TaskCollection))
+	for _, task := range w.TaskCollection {
+		known[task.Name] = struct{}{}
+	}
+
+	selected := make(map[string]struct{})
+	for _, target := range selection.Targets {
+		if _, ok := known[target]; !ok {
+			return fmt.Errorf("unknown target %q", target)
+		}
+		selected[target] = struct{}{}
+	}
+	// Skip tags alone filter every task.
+	all := len(selection.Targets) == 0 && len(selection.Tags) == 0
+	for _, task := range w.TaskCollection {
+		if all || task.HasTag(selection.Tags) {
+			selected[task.Name] = struct{}{}
+		}
+	}
+	if !selection.Only {
+		for name := range selected {
+			for _, dependency := range w.DAG.Dependencies(name) {
+				selected[dependency] = struct{}{}
+			}
+		}
+	}
+
+	var tasks []workflow.Task
+	for _, task := range w.TaskCollection {
+		if _, ok := selected[task.Name]; !ok || task.HasTag(selection.SkipTags) {
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	kept := make(map[string]struct{}, len(tasks))
+	for _, task := range tasks {
+		kept[task.Name] = struct{}{}
+	}
+	for i, task := range tasks {
+		var dependsOn []string
+		for _, dependency := range task.DependsOn {
+			if _, ok := kept[dependency]; ok {
+				dependsOn = append(dependsOn, dependency)
+			}
+		}
+		tasks[i].DependsOn = dependsOn
+	}
+	if len(tasks) == 0 {
+		return fmt.Errorf("no task matches the selection")
+	}
+
+	taskDAG, err := newDAG(tasks)
+	if err != nil {
+		return err
+	}
+	w.TaskCollection = tasks
+	w.DAG = taskDAG
+	return nil
+}
@@ -0,0 +1,180 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"gotasker/src/runner"
+	"gotasker/src/workflow"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// WorkflowAction is the name of the built-in action running another workflow
+// file as a single task.
+const WorkflowAction = "workflow"
+
+func init() {
+	runner.Register(WorkflowAction, func(params map[string]interface{}) (runner.Runner, error) {
+		file, _ := params["file"].(string)
+		if file == "" {
+			return nil, fmt.Errorf("the workflow action requires a file")
+		}
+		sub := &SubWorkflow{File: file}
+		if value, ok := params["variables"]; ok && value != nil {
+			variables, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("variables must be a map, got %v", value)
+			}
+			sub.Variables = variables
+		}
+		switch threads := params["threads"].(type) {
+		case nil:
+		case float64:
+			sub.Threads = int(threads)
+		case int:
+			sub.Threads = threads
+		default:
+			return nil, fmt.Errorf("threads must be a number, got %v", threads)
+		}
+		sub.Env = make(map[string]string)
+		for _, key := range []string{"workflow-env", "env"} {
+			if env, ok := params[key].(map[string]interface{}); ok {
+				for k, v := range workflowEnv(env) {
+					sub.Env[k] = v
+				}
+			}
+		}
+		// Tasks of the sub-workflow get output files of their own.
+		delete(sub.Env, workflow.OutputFileEnv)
+		return sub, nil
+	})
+}
+
+// SubWorkflow runs a workflow file with an engine of its own, as a single
+// task of its parent: it succeeds when no task of the sub-workflow failed,
+// timed out or was canceled.
+type SubWorkflow struct {
+	// File is the workflow file, relative to the parent workflow file.
+	File string
+	// Variables override the variables of the sub-workflow.
+	Variables map[string]interface{}
+	// Threads is the maximum number of parallel tasks of the sub-workflow,
+	// that of the parent when zero.
+	Threads int
+	// Env is given to every task of the sub-workflow, below its own env.
+	Env map[string]string
+}
+
+// parentKey is the context key of the engine running an action.
+type parentKey struct{}
+
+// ExecuteContext loads and runs the sub-workflow. Its output goes to the
+// output of the parent task, and aborting ctx aborts it.
+func (s *SubWorkflow) ExecuteContext(ctx context.Context) (*runner.Result, error) {
+	parent, _ := ctx.Value(parentKey{}).(*Engine)
+	path := s.File
+	if parent != nil && parent.File != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(parent.File), path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for e := parent; e != nil; e = e.parent {
+		if e.File == abs {
+			return nil, fmt.Errorf("workflow %s runs itself", s.File)
+		}
+	}
+
+	opts := workflow.Options{Variables: s.Variables}
+	if parent != nil {
+		opts.Lenient = parent.Lenient
+	}
+	wf, err := workflow.NewWorkflowWithOptions(path, opts)
+	if err != nil {
+		return nil, fmt.Errorf("workflow %s: %w", s.File, err)
+	}
+	threads := s.Threads
+	if threads < 1 {
+		threads = runtime.NumCPU()
+		if parent != nil {
+			threads = parent.Threads
+		}
+	}
+	child, err := NewEngine(wf, threads, false)
+	if err != nil {
+		return nil, fmt.Errorf("workflow %s: %w", s.File, err)
+	}
+	child.parent = parent
+	if parent != nil {
+		child.Output = parent.Output
+		child.Timestamps = parent.Timestamps
+		child.Color = parent.Color
+		child.GracePeriod = parent.GracePeriod
+		child.Lenient = parent.Lenient
+	}
+	env := make(map[string]string, len(s.Env)+len(child.Env))
+	for k, v := range s.Env {
+		env[k] = v
+	}
+	for k, v := range child.Env {
+		env[k] = v
+	}
+	child.Env = env
+
+	var stdout, stderr bytes.Buffer
+	liveStdout, liveStderr := runner.OutputFrom(ctx)
+	// The child writes its output under its output lock, one line or block
+	// at a time, which the output of the parent task expects.
+	child.stdout = runner.Tee(&stdout, liveStdout)
+	child.stderr = runner.Tee(&stderr, liveStderr)
+
+	// Aborting ctx aborts the child, and forcing the kill of the parent
+	// task, on a second abort, aborts the child again to kill its tasks.
+	force := runner.KillOptionsFrom(ctx).Force
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			child.AbortExecution()
+		case <-done:
+			return
+		}
+		select {
+		case <-force:
+			child.AbortExecution()
+		case <-done:
+		}
+	}()
+	result := &runner.Result{StartTime: time.Now()}
+	runErr := child.Run()
+	close(done)
+	result.EndTime = time.Now()
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	result.Tasks = child.taskStatuses()
+
+	switch {
+	case ctx.Err() != nil:
+		result.ExitCode = -1
+		return result, fmt.Errorf("workflow %s: %w", s.File, ctx.Err())
+	case runErr != nil:
+		result.ExitCode = 1
+		return result, fmt.Errorf("workflow %s: %w", s.File, runErr)
+	}
+	var failed []string
+	for _, task := range result.Tasks {
+		switch task.Status {
+		case "failed", "timed-out", "canceled":
+			failed = append(failed, task.Name+" "+task.Status)
+		}
+	}
+	if len(failed) > 0 {
+		result.ExitCode = 1
+		return result, fmt.Errorf("workflow %s: %s", s.File, strings.Join(failed, ", "))
+	}
+	return result, nil
+}
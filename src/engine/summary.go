@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"fmt"
+	"gotasker/src/runner"
+	"io"
+	"strings"
+	"time"
+)
+
+// printSummary prints the final status of every task, with the tasks of
+// sub-workflows nested under the task that ran them.
+func (w *Engine) printSummary() {
+	out := w.out()
+	fmt.Fprintln(out, "\n=== Execution Summary ===")
+	for _, task := range w.TaskCollection {
+		status := w.DAG.GetStatus(task.Name)
+		var details []string
+		result := w.GetResult(task.Name)
+		if _, ok := w.resumed[task.Name]; ok {
+			details = append(details, "previous run")
+		} else if result != nil && status != "up-to-date" {
+			details = append(details, result.Duration().Round(time.Millisecond).String())
+			if result.Signal != "" {
+				details = append(details, "signal: "+result.Signal)
+			} else if result.ExitCode > 0 {
+				details = append(details, fmt.Sprintf("exit code %d", result.ExitCode))
+			}
+		}
+		if attempts := w.DAG.GetAttempts(task.Name); attempts > 1 {
+			details = append(details, fmt.Sprintf("%d attempts", attempts))
+		}
+		if cleanup := w.DAG.GetCleanupStatus(task.Name); cleanup != "" {
+			details = append(details, "cleanup: "+cleanup)
+		}
+		if len(details) > 0 {
+			fmt.Fprintf(out, "  %s: %s (%s)\n", task.Name, status, strings.Join(details, ", "))
+		} else {
+			fmt.Fprintf(out, "  %s: %s\n", task.Name, status)
+		}
+		if result != nil && status != "up-to-date" {
+			printTaskStatuses(out, result.Tasks, "    ")
+		}
+	}
+}
+
+// printTaskStatuses prints the statuses of the tasks of a sub-workflow, and
+// of their own sub-workflows, with the given indentation.
+func printTaskStatuses(out io.Writer, tasks []runner.TaskStatus, indent string) {
+	for _, task := range tasks {
+		if task.Duration > 0 {
+			fmt.Fprintf(out, "%s%s: %s (%s)\n", indent, task.Name, task.Status, task.Duration.Round(time.Millisecond))
+		} else {
+			fmt.Fprintf(out, "%s%s: %s\n", indent, task.Name, task.Status)
+		}
+		printTaskStatuses(out, task.Tasks, indent+"  ")
+	}
+}
+
+// taskStatuses returns the final status of every task, for the result of
+// the task running this engine as a sub-workflow.
+func (w *Engine) taskStatuses() []runner.TaskStatus {
+	statuses := make([]runner.TaskStatus, 0, len(w.TaskCollection))
+	for _, task := range w.TaskCollection {
+		status := runner.TaskStatus{Name: task.Name, Status: w.DAG.GetStatus(task.Name)}
+		if result := w.GetResult(task.Name); result != nil && status.Status != "up-to-date" {
+			status.Duration = result.Duration()
+			status.Tasks = result.Tasks
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
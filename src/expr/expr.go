@@ -0,0 +1,466 @@
+// Package expr implements the small expression language of task conditions.
+//
+// An expression combines literals ("text", 'text', 42, true, false, null),
+// variable paths (target, tasks.build.status, tasks["lib.build"].outputs.version),
+// comparisons (== != < <= > >=), boolean logic (&& || ! and parentheses) and
+// the functions exists(path) and env(name). Values compare as numbers when
+// both sides are numbers or numeric strings, and as strings otherwise.
+package expr
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Expression is a parsed expression.
+type Expression struct {
+	source string
+	root   node
+}
+
+// Parse parses an expression.
+func Parse(source string) (*Expression, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos+1)
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+// Eval parses an expression and evaluates it as a condition.
+func Eval(source string, vars map[string]interface{}) (bool, error) {
+	e, err := Parse(source)
+	if err != nil {
+		return false, err
+	}
+	return e.Bool(vars)
+}
+
+// String returns the source of the expression.
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression with the given variables. Paths that do not
+// exist evaluate to nil.
+func (e *Expression) Eval(vars map[string]interface{}) (interface{}, error) {
+	return e.root.eval(vars)
+}
+
+// Bool evaluates the expression as a condition: false, null, zero and the
+// empty string are false, everything else is true.
+func (e *Expression) Bool(vars map[string]interface{}) (bool, error) {
+	value, err := e.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	return truthy(value), nil
+}
+
+// functions are the functions expressions can call.
+var functions = map[string]func(args []interface{}) (interface{}, error){
+	"exists": func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("exists takes one path")
+		}
+		_, err := os.Stat(toString(args[0]))
+		return err == nil, nil
+	},
+	"env": func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("env takes one variable name")
+		}
+		return os.Getenv(toString(args[0])), nil
+	},
+}
+
+// node is a node of the syntax tree.
+type node interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (n literal) eval(map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+// path looks up a variable and then, in turn, each of its keys.
+type path struct {
+	name string
+	keys []node
+}
+
+func (n path) eval(vars map[string]interface{}) (interface{}, error) {
+	value, ok := vars[n.name]
+	if !ok {
+		return nil, nil
+	}
+	for _, keyNode := range n.keys {
+		key, err := keyNode.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[toString(key)]
+		case map[string]string:
+			value = v[toString(key)]
+		case []interface{}:
+			i, ok := toNumber(key)
+			if !ok || i < 0 || int(i) >= len(v) {
+				return nil, nil
+			}
+			value = v[int(i)]
+		default:
+			return nil, nil
+		}
+	}
+	return value, nil
+}
+
+type call struct {
+	name string
+	args []node
+}
+
+func (n call) eval(vars map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	return functions[n.name](args)
+}
+
+type not struct {
+	operand node
+}
+
+func (n not) eval(vars map[string]interface{}) (interface{}, error) {
+	value, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	return !truthy(value), nil
+}
+
+type binary struct {
+	op          string
+	left, right node
+}
+
+func (n binary) eval(vars map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "&&":
+		if !truthy(left) {
+			return false, nil
+		}
+		right, err := n.right.eval(vars)
+		return truthy(right), err
+	case "||":
+		if truthy(left) {
+			return true, nil
+		}
+		right, err := n.right.eval(vars)
+		return truthy(right), err
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	}
+	cmp, err := compare(left, right)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", n.op, err)
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+// truthy reports whether a value counts as true in a condition.
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	}
+	return true
+}
+
+// equal compares two values, as numbers if both can be read as numbers.
+func equal(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if x, ok := toNumber(a); ok {
+		if y, ok := toNumber(b); ok {
+			return x == y
+		}
+	}
+	return toString(a) == toString(b)
+}
+
+// compare orders two values, as numbers if both can be read as numbers and
+// as strings if both are strings.
+func compare(a, b interface{}) (int, error) {
+	if x, ok := toNumber(a); ok {
+		if y, ok := toNumber(b); ok {
+			switch {
+			case x < y:
+				return -1, nil
+			case x > y:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	x, okA := a.(string)
+	y, okB := b.(string)
+	if !okA || !okB {
+		return 0, fmt.Errorf("cannot order %v and %v", a, b)
+	}
+	switch {
+	case x < y:
+		return -1, nil
+	case x > y:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// toNumber reads a number or a numeric string.
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// toString formats a value for string comparisons and function arguments.
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// parser is a recursive descent parser over the tokens of an expression.
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// expect consumes a token of the given kind.
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	tok := p.next()
+	if tok.kind != kind {
+		return tok, fmt.Errorf("expected %s at position %d", what, tok.pos+1)
+	}
+	return tok, nil
+}
+
+// parseBinary parses a left-associative chain of the given operators.
+func (p *parser) parseBinary(operand func() (node, error), ops ...string) (node, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.kind != tokenOperator || !contains(ops, tok.text) {
+			return left, nil
+		}
+		p.next()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: tok.text, left: left, right: right}
+	}
+}
+
+func (p *parser) parseOr() (node, error) {
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.parseBinary(p.parseEquality, "&&")
+}
+
+func (p *parser) parseEquality() (node, error) {
+	return p.parseBinary(p.parseComparison, "==", "!=")
+}
+
+func (p *parser) parseComparison() (node, error) {
+	return p.parseBinary(p.parseUnary, "<", "<=", ">", ">=")
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if tok := p.peek(); tok.kind == tokenOperator && tok.text == "!" {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return not{operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenString, tokenNumber:
+		return literal{value: tok.value}, nil
+	case tokenLParen:
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRParen, ")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return literal{value: true}, nil
+		case "false":
+			return literal{value: false}, nil
+		case "null":
+			return literal{value: nil}, nil
+		}
+		if p.peek().kind == tokenLParen {
+			return p.parseCall(tok)
+		}
+		return p.parsePath(tok)
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos+1)
+}
+
+// parseCall parses the arguments of a call to the named function.
+func (p *parser) parseCall(name token) (node, error) {
+	if _, ok := functions[name.text]; !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", name.text, name.pos+1)
+	}
+	p.next()
+	c := call{name: name.text}
+	if p.peek().kind == tokenRParen {
+		p.next()
+		return c, nil
+	}
+	for {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, arg)
+		tok := p.next()
+		if tok.kind == tokenRParen {
+			return c, nil
+		}
+		if tok.kind != tokenComma {
+			return nil, fmt.Errorf("expected , or ) at position %d", tok.pos+1)
+		}
+	}
+}
+
+// parsePath parses the keys following a variable name.
+func (p *parser) parsePath(name token) (node, error) {
+	n := path{name: name.text}
+	for {
+		switch p.peek().kind {
+		case tokenDot:
+			p.next()
+			key, err := p.expect(tokenIdent, "a name after .")
+			if err != nil {
+				return nil, err
+			}
+			n.keys = append(n.keys, literal{value: key.text})
+		case tokenLBracket:
+			p.next()
+			key, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(tokenRBracket, "]"); err != nil {
+				return nil, err
+			}
+			n.keys = append(n.keys, key)
+		default:
+			return n, nil
+		}
+	}
+}
+
+// contains reports whether s is one of list.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
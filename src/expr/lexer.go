@@ -0,0 +1,132 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tokenKind identifies the kind of a token.
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenLBracket
+	tokenRBracket
+	tokenDot
+	tokenComma
+)
+
+// token is a lexical token of an expression.
+type token struct {
+	kind tokenKind
+	text string
+	// value holds the decoded value of string and number tokens.
+	value interface{}
+	pos   int
+}
+
+// operators lists the operators, longest first so "<=" wins over "<".
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!"}
+
+// lex splits an expression into tokens.
+func lex(input string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(input); {
+		c := rune(input[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(input) && input[end] != input[i] {
+				if input[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(input) {
+				return nil, fmt.Errorf("unterminated string at position %d", i+1)
+			}
+			text := input[i : end+1]
+			value, err := unquote(text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %d: %w", i+1, err)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, value: value, pos: i})
+			i = end + 1
+		case unicode.IsDigit(c):
+			end := i
+			for end < len(input) && (unicode.IsDigit(rune(input[end])) || input[end] == '.') {
+				end++
+			}
+			value, err := strconv.ParseFloat(input[i:end], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", input[i:end], i+1)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: input[i:end], value: value, pos: i})
+			i = end
+		case unicode.IsLetter(c) || c == '_':
+			end := i
+			for end < len(input) && isIdentRune(rune(input[end])) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: input[i:end], pos: i})
+			i = end
+		default:
+			kind, text := punctuation(input[i:])
+			if text == "" {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i+1)
+			}
+			tokens = append(tokens, token{kind: kind, text: text, pos: i})
+			i += len(text)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(input)}), nil
+}
+
+// isIdentRune reports whether r can appear in an identifier after its first
+// character. Dashes are allowed so task and output names read naturally.
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-'
+}
+
+// punctuation returns the operator or delimiter at the start of s.
+func punctuation(s string) (tokenKind, string) {
+	for _, op := range operators {
+		if strings.HasPrefix(s, op) {
+			return tokenOperator, op
+		}
+	}
+	switch s[0] {
+	case '(':
+		return tokenLParen, "("
+	case ')':
+		return tokenRParen, ")"
+	case '[':
+		return tokenLBracket, "["
+	case ']':
+		return tokenRBracket, "]"
+	case '.':
+		return tokenDot, "."
+	case ',':
+		return tokenComma, ","
+	}
+	return tokenEOF, ""
+}
+
+// unquote decodes a string literal. Double quoted strings follow the Go
+// syntax; single quoted ones are taken literally except for \'.
+func unquote(text string) (string, error) {
+	if text[0] == '\'' {
+		return strings.ReplaceAll(text[1:len(text)-1], `\'`, `'`), nil
+	}
+	return strconv.Unquote(text)
+}
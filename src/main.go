@@ -2,64 +2,354 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"gotasker/src/engine"
+	"gotasker/src/runner"
+	"gotasker/src/state"
 	"gotasker/src/workflow"
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"syscall"
 )
 
 func main() {
-	filePath := flag.String("file", "", "Path to the workflow YAML or JSON file (required)")
-	flag.StringVar(filePath, "f", "", "Path to the workflow YAML or JSON file (shorthand)")
+	// The command is optional so "gotasker -f wf.yaml" keeps working.
+	command, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "run", "resume":
+		os.Exit(run(command, args))
+	case "validate":
+		os.Exit(validate(args))
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown command %q. Use run, resume or validate.\n", command)
+		os.Exit(2)
+	}
+}
+
+// run loads and runs a workflow. The resume command, or the -rerun-failed
+// flag, restores the progress of the previous run first. It returns the exit
+// code of the process.
+func run(command string, args []string) int {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: gotasker [run|resume] -f <workflow> [flags] [targets...]\n")
+		flags.PrintDefaults()
+	}
+
+	filePath := flags.String("file", "", "Path to the workflow YAML or JSON file (required)")
+	flags.StringVar(filePath, "f", "", "Path to the workflow YAML or JSON file (shorthand)")
+
+	dryRun := flags.Bool("dry-run", false, "Print execution plan without running tasks")
+	flags.BoolVar(dryRun, "d", false, "Print execution plan without running tasks (shorthand)")
+
+	threads := flags.Int("threads", runtime.NumCPU(), "Maximum number of parallel tasks")
+	flags.IntVar(threads, "t", runtime.NumCPU(), "Maximum number of parallel tasks (shorthand)")
+
+	policy := flags.String("policy", "", "Cancel policy when a task fails: abort-related-flows, abort-all or continue (overrides the workflow on-failure)")
+
+	output := flags.String("output", engine.OutputPrefixed, "How task output is shown: prefixed (streamed line by line), grouped (printed when the task ends) or quiet")
+	timestamps := flags.Bool("timestamps", false, "Prefix streamed output lines with the time")
+	color := flags.Bool("color", false, "Color the task name of streamed output lines")
+
+	gracePeriod := flags.Duration("grace-period", runner.DefaultGracePeriod, "Time running tasks get to exit after SIGTERM before they are killed")
+
+	timeout := flags.Duration("timeout", 0, "Maximum duration of the whole workflow run, e.g. 10m (overrides the workflow timeout)")
+
+	rerunFailed := flags.Bool("rerun-failed", false, "Resume the previous run, rerunning only the tasks that failed, timed out or were canceled")
+
+	force := flags.Bool("force", false, "Run tasks declaring inputs or output files even if they are up to date")
+
+	stateDir := flags.String("state-dir", "", "Directory of the checkpoint and fingerprint files (default: .gotasker next to the workflow file)")
+	noState := flags.Bool("no-state", false, "Neither checkpoint the run nor keep fingerprints, e.g. for workflows in read-only directories")
+
+	tags := flags.String("tags", "", "Comma-separated tags; run only the tasks having one of them (and their dependencies)")
+	skipTags := flags.String("skip-tags", "", "Comma-separated tags; leave out the tasks having one of them")
+	only := flags.Bool("only", false, "Run the targets and tagged tasks without their dependencies")
+
+	lenient := flags.Bool("lenient", false, "Render templates reading undefined variables as <no value> instead of failing, even in strict workflows")
 
-	dryRun := flag.Bool("dry-run", false, "Print execution plan without running tasks")
-	flag.BoolVar(dryRun, "d", false, "Print execution plan without running tasks (shorthand)")
+	var vars, varFiles listFlag
+	flags.Var(&vars, "var", "Set a variable, as NAME=VALUE; numbers, booleans and lists like [a, b] keep their type (repeatable)")
+	flags.Var(&varFiles, "var-file", "Load variables from a YAML or JSON file (repeatable)")
 
-	threads := flag.Int("threads", runtime.NumCPU(), "Maximum number of parallel tasks")
-	flag.IntVar(threads, "t", runtime.NumCPU(), "Maximum number of parallel tasks (shorthand)")
+	help := flags.Bool("help", false, "Show this help; with -f, show the inputs of the workflow as well")
+	flags.BoolVar(help, "h", false, "Show this help (shorthand)")
 
-	flag.Parse()
+	// Targets may come before, between or after the flags.
+	var targets []string
+	for flags.Parse(args); flags.NArg() > 0; flags.Parse(args) {
+		targets = append(targets, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+
+	if *help {
+		if *filePath == "" {
+			flags.SetOutput(os.Stdout)
+			flags.Usage()
+			return 0
+		}
+		return workflowUsage(command, *filePath, flags)
+	}
 
 	if *filePath == "" {
 		fmt.Fprintln(os.Stderr, "Error: workflow file path is required. Use -file or -f flag.")
-		flag.Usage()
-		os.Exit(1)
+		flags.Usage()
+		return 1
 	}
 
 	if *threads < 1 {
 		*threads = 1
 	}
 
+	if !engine.IsValidOutputMode(*output) {
+		fmt.Fprintf(os.Stderr, "Error: unknown output mode %q. Use prefixed, grouped or quiet.\n", *output)
+		return 1
+	}
+
+	if *policy != "" && !workflow.IsValidCancelPolicy(*policy) {
+		fmt.Fprintf(os.Stderr, "Error: unknown policy %q. Use abort-related-flows, abort-all or continue.\n", *policy)
+		return 1
+	}
+
+	if *noState && (command == "resume" || *rerunFailed) {
+		fmt.Fprintln(os.Stderr, "Error: -no-state cannot resume a previous run.")
+		return 1
+	}
+
 	// Load workflow
-	wf, err := workflow.NewWorkflow(*filePath)
+	opts, err := workflow.ResolveOverrides(os.Environ(), varFiles, vars)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	opts.Lenient = *lenient
+	wf, err := workflow.NewWorkflowWithOptions(*filePath, opts)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error loading workflow: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
 
 	// Create engine
 	eng, err := engine.NewEngine(wf, *threads, *dryRun)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error creating engine: %v\n", err)
-		os.Exit(1)
+		return 1
+	}
+	if *timeout > 0 {
+		eng.Timeout = *timeout
+	}
+	selection := engine.Selection{
+		Targets:  targets,
+		Tags:     splitList(*tags),
+		SkipTags: splitList(*skipTags),
+		Only:     *only,
+	}
+	if err := eng.Select(selection); err != nil {
+		fmt.Fprintf(os.Stderr, "Error selecting tasks: %v\n", err)
+		return 1
+	}
+	eng.GracePeriod = *gracePeriod
+	eng.Lenient = *lenient
+	eng.Output = *output
+	eng.Timestamps = *timestamps
+	eng.Color = *color
+	if *policy != "" {
+		eng.Policy = *policy
 	}
 
-	// Set up signal handling for graceful shutdown
-	sigChan := make(chan os.Signal, 1)
+	// Checkpoint the run, restoring the previous one when resuming, and load
+	// the fingerprints of incremental tasks.
+	if !*dryRun && !*noState {
+		var store *state.Store
+		if command == "resume" || *rerunFailed {
+			store, err = state.Load(*filePath, *stateDir)
+			if errors.Is(err, os.ErrNotExist) {
+				fmt.Fprintf(os.Stderr, "Error: no previous run of %s to resume.\n", *filePath)
+				return 1
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error loading checkpoint: %v\n", err)
+				return 1
+			}
+			if store.Changed() {
+				fmt.Fprintf(os.Stderr, "Warning: %s changed since the previous run.\n", *filePath)
+				if err := store.UpdateHash(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: cannot checkpoint the new workflow: %v\n", err)
+				}
+			}
+			remaining := eng.Resume(store.Checkpoint(), *rerunFailed)
+			fmt.Printf("Resuming %s: %d of %d tasks left to run.\n", *filePath, remaining, len(wf.Tasks))
+		} else {
+			store, err = state.New(*filePath, *stateDir)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error creating checkpoint: %v\n", err)
+				return 1
+			}
+		}
+		eng.Checkpoint = store
+
+		cache, err := state.LoadCache(*filePath, *stateDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading cache: %v\n", err)
+			return 1
+		}
+		eng.Cache = cache
+		eng.IgnoreCache = *force
+	}
+
+	// Set up signal handling for graceful shutdown. The first signal
+	// terminates running tasks, a second one kills them immediately.
+	sigChan := make(chan os.Signal, 2)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
-		sig := <-sigChan
-		fmt.Fprintf(os.Stderr, "\nReceived signal: %v\n", sig)
-		eng.AbortExecution()
+		for sig := range sigChan {
+			fmt.Fprintf(os.Stderr, "\nReceived signal: %v\n", sig)
+			eng.AbortExecution()
+		}
 	}()
 
 	// Run the engine
 	if err := eng.Run(); err != nil {
 		fmt.Fprintf(os.Stderr, "Execution error: %v\n", err)
-		os.Exit(1)
+		return 1
+	}
+	return 0
+}
+
+// workflowUsage prints how to run a workflow, like the usage of a command:
+// its description, its inputs and the flags of run. It returns the exit code
+// of the process.
+func workflowUsage(command string, path string, flags *flag.FlagSet) int {
+	description, inputs, err := workflow.ReadInputs(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading workflow: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Usage: gotasker %s -f %s [-var NAME=VALUE]... [flags] [targets...]\n", command, path)
+	if description != "" {
+		fmt.Printf("\n%s\n", strings.TrimSpace(description))
+	}
+	if len(inputs) > 0 {
+		fmt.Printf("\nInputs (set with -var, -var-file or %sNAME):\n", workflow.VarEnvPrefix)
+		for _, input := range inputs {
+			line := fmt.Sprintf("  -var %s=<%s>", input.Name, input.Usage())
+			if input.Required && input.Default == nil {
+				line += " (required)"
+			}
+			fmt.Println(line)
+			var details []string
+			if input.Description != "" {
+				details = append(details, input.Description)
+			}
+			if input.Pattern != "" {
+				details = append(details, fmt.Sprintf("(matching %s)", input.Pattern))
+			}
+			if input.Default != nil {
+				details = append(details, fmt.Sprintf("(default %s)", formatValue(input.Default)))
+			}
+			if len(details) > 0 {
+				fmt.Printf("    \t%s\n", strings.Join(details, " "))
+			}
+		}
+	}
+	fmt.Println("\nFlags:")
+	flags.SetOutput(os.Stdout)
+	flags.PrintDefaults()
+	return 0
+}
+
+// formatValue prints a value as it would be given with -var, lists as
+// [a, b].
+func formatValue(value interface{}) string {
+	list, ok := value.([]interface{})
+	if !ok {
+		return fmt.Sprint(value)
+	}
+	items := make([]string, len(list))
+	for i, item := range list {
+		items[i] = formatValue(item)
+	}
+	return "[" + strings.Join(items, ", ") + "]"
+}
+
+// validate checks workflow files without running them and prints their
+// problems, one per line, as file:line:column: message. It returns 1 if any
+// file has a problem.
+func validate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: gotasker validate [-f <workflow>] [workflows...]\n")
+		flags.PrintDefaults()
+	}
+	filePath := flags.String("file", "", "Path to the workflow YAML or JSON file")
+	flags.StringVar(filePath, "f", "", "Path to the workflow YAML or JSON file (shorthand)")
+	var vars, varFiles listFlag
+	flags.Var(&vars, "var", "Set a variable, as NAME=VALUE (repeatable)")
+	flags.Var(&varFiles, "var-file", "Load variables from a YAML or JSON file (repeatable)")
+
+	var files []string
+	for flags.Parse(args); flags.NArg() > 0; flags.Parse(args) {
+		files = append(files, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	if *filePath != "" {
+		files = append([]string{*filePath}, files...)
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: workflow file path is required. Use -file or -f flag.")
+		flags.Usage()
+		return 1
+	}
+
+	opts, err := workflow.ResolveOverrides(os.Environ(), varFiles, vars)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	opts.CheckAction = engine.CheckAction
+	opts.CheckCondition = engine.CheckCondition
+	code := 0
+	for _, file := range files {
+		problems := workflow.Validate(file, opts)
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) > 0 {
+			code = 1
+		}
+	}
+	if code == 0 {
+		fmt.Fprintf(os.Stderr, "No problems found in %s.\n", strings.Join(files, ", "))
+	}
+	return code
+}
+
+// listFlag is a flag that can be repeated, collecting its values in order.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
 }
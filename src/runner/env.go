@@ -0,0 +1,105 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"gotasker/src/workflow"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// setCommandEnv applies the dir, workflow-env, env, env-file and inherit-env
+// params to cmd. Variables are taken, from lowest to highest precedence, from
+// the inherited environment, the workflow-env map, the env file and the env
+// map. Without any of these params the command keeps the environment of
+// gotasker.
+func setCommandEnv(cmd *exec.Cmd, params map[string]interface{}) error {
+	dir, _ := params["dir"].(string)
+	cmd.Dir = dir
+
+	inherit, err := workflow.ParseInheritEnv(params["inherit-env"])
+	if err != nil {
+		return err
+	}
+	envFile, _ := params["env-file"].(string)
+	workflowEnv, _ := params["workflow-env"].(map[string]interface{})
+	envMap, _ := params["env"].(map[string]interface{})
+	if inherit == nil && envFile == "" && len(workflowEnv) == 0 && len(envMap) == 0 {
+		return nil
+	}
+
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		if inherit == nil || inherit[key] {
+			env[key] = value
+		}
+	}
+	for k, v := range workflowEnv {
+		env[k] = fmt.Sprint(v)
+	}
+	if envFile != "" {
+		if !filepath.IsAbs(envFile) && dir != "" {
+			envFile = filepath.Join(dir, envFile)
+		}
+		fileEnv, err := ReadEnvFile(envFile)
+		if err != nil {
+			return err
+		}
+		for k, v := range fileEnv {
+			env[k] = v
+		}
+	}
+	for k, v := range envMap {
+		env[k] = fmt.Sprint(v)
+	}
+
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	cmd.Env = make([]string, 0, len(keys))
+	for _, k := range keys {
+		cmd.Env = append(cmd.Env, k+"="+env[k])
+	}
+	return nil
+}
+
+// ReadEnvFile reads KEY=VALUE lines from a file. Blank lines and lines
+// starting with # are ignored, an optional "export " prefix is dropped and
+// values may be wrapped in single or double quotes.
+func ReadEnvFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading env file: %w", err)
+	}
+	defer file.Close()
+
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading env file: %w", err)
+	}
+	return env, nil
+}
@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+// outputKey is the context key for the writers receiving live output.
+type outputKey struct{}
+
+// streams holds the writers receiving the live output of an action.
+type streams struct {
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// WithOutput returns a copy of ctx asking actions to copy their stdout and
+// stderr to the given writers while they run, in addition to capturing them.
+func WithOutput(ctx context.Context, stdout, stderr io.Writer) context.Context {
+	return context.WithValue(ctx, outputKey{}, streams{stdout: stdout, stderr: stderr})
+}
+
+// OutputFrom returns the writers carried by ctx, or nil ones. Actions
+// registered from other packages use it to stream their output.
+func OutputFrom(ctx context.Context) (io.Writer, io.Writer) {
+	s, _ := ctx.Value(outputKey{}).(streams)
+	return s.stdout, s.stderr
+}
+
+// Tee returns a writer writing to capture and, if not nil, to stream.
+func Tee(capture io.Writer, stream io.Writer) io.Writer {
+	if stream == nil {
+		return capture
+	}
+	return io.MultiWriter(capture, stream)
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent writes, used to capture
+// stdout and stderr together.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// Write appends p to the buffer.
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// String returns the buffer contents.
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
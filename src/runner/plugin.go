@@ -0,0 +1,136 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PluginPrefix is the prefix of the executables that provide out-of-process
+// actions: the action "deploy" is provided by "gotasker-action-deploy".
+const PluginPrefix = "gotasker-action-"
+
+// PluginRequest is the JSON document written to the stdin of a plugin.
+type PluginRequest struct {
+	// Action is the name the task used in `do.this`.
+	Action string `json:"action"`
+	// Params are the parameters of the task's `with` block.
+	Params map[string]interface{} `json:"params"`
+}
+
+// PluginResponse is the JSON document a plugin writes to its stdout.
+type PluginResponse struct {
+	// Status is "success" or "failure".
+	Status string `json:"status"`
+	// Outputs are named values produced by the action.
+	Outputs map[string]string `json:"outputs"`
+	// Logs are lines of human readable output.
+	Logs []string `json:"logs"`
+	// Error explains a failure.
+	Error string `json:"error"`
+}
+
+// Plugin runs an action implemented by an external executable.
+type Plugin struct {
+	// Path is the plugin executable.
+	Path string
+	// Request is sent to the plugin on stdin.
+	Request PluginRequest
+}
+
+// FindPlugin looks for the executable providing the named action, first in
+// the given directories and then on PATH.
+func FindPlugin(action string, dirs []string) (string, bool) {
+	name := PluginPrefix + action
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return path, true
+		}
+	}
+	if path, err := exec.LookPath(name); err == nil {
+		return path, true
+	}
+	return "", false
+}
+
+// Resolve returns the factory for an action: the registered one if any, or
+// else one running the plugin found by FindPlugin.
+func Resolve(action string, pluginDirs []string) (Factory, error) {
+	if factory, ok := Lookup(action); ok {
+		return factory, nil
+	}
+	path, ok := FindPlugin(action, pluginDirs)
+	if !ok {
+		return nil, fmt.Errorf("unknown action %q: not registered and no %s%s executable found", action, PluginPrefix, action)
+	}
+	return func(params map[string]interface{}) (Runner, error) {
+		return &Plugin{
+			Path:    path,
+			Request: PluginRequest{Action: action, Params: params},
+		}, nil
+	}, nil
+}
+
+// ExecuteContext runs the plugin, sending it the request on stdin and
+// decoding its response from stdout. Like the process action, it honours the
+// dir, env, env-file and inherit-env params. The logs of the response become the
+// stdout of the result and its outputs the result outputs. Stderr is streamed
+// live to the writers set with WithOutput, which also receive the logs once
+// the response arrives. It fails if the plugin exits with a non-zero status,
+// writes an invalid response or reports a failure.
+func (p *Plugin) ExecuteContext(ctx context.Context) (*Result, error) {
+	request, err := json.Marshal(p.Request)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding plugin request: %w", err)
+	}
+
+	cmd := exec.Command(p.Path)
+	if err := setCommandEnv(cmd, p.Request.Params); err != nil {
+		return nil, fmt.Errorf("error preparing plugin %s: %w", filepath.Base(p.Path), err)
+	}
+	cmd.Stdin = bytes.NewReader(request)
+	var stdout, stderr bytes.Buffer
+	liveStdout, liveStderr := OutputFrom(ctx)
+	cmd.Stdout = &stdout
+	cmd.Stderr = Tee(&stderr, liveStderr)
+
+	result := &Result{StartTime: time.Now()}
+	err = runCommand(ctx, cmd)
+	result.EndTime = time.Now()
+	result.Stderr = stderr.String()
+	result.setProcessState(cmd.ProcessState)
+	if err != nil {
+		return result, fmt.Errorf("error executing plugin %s: %w", filepath.Base(p.Path), err)
+	}
+
+	var response PluginResponse
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		result.Stdout = stdout.String()
+		return result, fmt.Errorf("plugin %s wrote an invalid response: %w", filepath.Base(p.Path), err)
+	}
+	result.Outputs = response.Outputs
+
+	if len(response.Logs) > 0 {
+		result.Stdout = strings.Join(response.Logs, "\n") + "\n"
+		if liveStdout != nil {
+			io.WriteString(liveStdout, result.Stdout)
+		}
+	}
+
+	switch response.Status {
+	case "success":
+		return result, nil
+	case "failure":
+		return result, fmt.Errorf("plugin %s failed: %s", filepath.Base(p.Path), response.Error)
+	default:
+		return result, fmt.Errorf("plugin %s returned unknown status %q", filepath.Base(p.Path), response.Status)
+	}
+}
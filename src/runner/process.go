@@ -0,0 +1,86 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// waitDelay is how long a killed process is waited for once its output pipes
+// are held open by orphaned children.
+const waitDelay = 2 * time.Second
+
+// DefaultGracePeriod is how long a process tree is given to exit after
+// SIGTERM before it is killed, unless the context says otherwise.
+const DefaultGracePeriod = 10 * time.Second
+
+// KillOptions controls how a running process tree is stopped once the context
+// of its execution is done.
+type KillOptions struct {
+	// GracePeriod is the time between SIGTERM and SIGKILL.
+	GracePeriod time.Duration
+	// Force, when closed, skips what remains of the grace period.
+	Force <-chan struct{}
+}
+
+// killOptionsKey is the context key for KillOptions.
+type killOptionsKey struct{}
+
+// WithKillOptions returns a copy of ctx carrying the given kill options.
+func WithKillOptions(ctx context.Context, opts KillOptions) context.Context {
+	return context.WithValue(ctx, killOptionsKey{}, opts)
+}
+
+// KillOptionsFrom returns the kill options carried by ctx, or the defaults.
+func KillOptionsFrom(ctx context.Context) KillOptions {
+	if opts, ok := ctx.Value(killOptionsKey{}).(KillOptions); ok {
+		return opts
+	}
+	return KillOptions{GracePeriod: DefaultGracePeriod}
+}
+
+// runCommand starts cmd in its own process group and waits for it to exit,
+// stopping the whole group when ctx is done. In that case the returned error
+// wraps ctx.Err().
+func runCommand(ctx context.Context, cmd *exec.Cmd) error {
+	setProcessGroup(cmd)
+	cmd.WaitDelay = waitDelay
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go stopWhenDone(ctx, cmd, done)
+	err := cmd.Wait()
+	close(done)
+
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("%w: %w", err, ctx.Err())
+	}
+	return err
+}
+
+// stopWhenDone waits until either the command exits (done is closed) or ctx is
+// done. In the latter case it sends SIGTERM to the command's process group and,
+// if the command is still running after the grace period or once a forced
+// kill is requested, SIGKILL.
+func stopWhenDone(ctx context.Context, cmd *exec.Cmd, done <-chan struct{}) {
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	opts := KillOptionsFrom(ctx)
+	_ = terminateProcessGroup(cmd)
+
+	timer := time.NewTimer(opts.GracePeriod)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return
+	case <-timer.C:
+	case <-opts.Force:
+	}
+	_ = killProcessGroup(cmd)
+}
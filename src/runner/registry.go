@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DefaultAction is the action used when a task does not name one.
+const DefaultAction = "process"
+
+// Factory builds a Runner for an action from the parameters of its `with` block.
+type Factory func(params map[string]interface{}) (Runner, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+func init() {
+	Register(DefaultAction, func(params map[string]interface{}) (Runner, error) {
+		path, _ := params["path"].(string)
+		if path == "" {
+			return nil, fmt.Errorf("the process action requires a path")
+		}
+		return NewExecution(path, params), nil
+	})
+}
+
+// Register makes an action available to workflows under the given name, so
+// tasks can use it in `do.this`. It panics if the name is empty, the factory
+// is nil or an action with that name is already registered.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if name == "" {
+		panic("runner: Register action with an empty name")
+	}
+	if factory == nil {
+		panic("runner: Register factory is nil for action " + name)
+	}
+	if _, dup := registry[name]; dup {
+		panic("runner: Register called twice for action " + name)
+	}
+	registry[name] = factory
+}
+
+// Lookup returns the factory registered under the given action name.
+func Lookup(name string) (Factory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	factory, ok := registry[name]
+	return factory, ok
+}
+
+// Actions returns the sorted names of the registered actions.
+func Actions() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New builds a Runner for the named action with the given parameters.
+func New(action string, params map[string]interface{}) (Runner, error) {
+	factory, ok := Lookup(action)
+	if !ok {
+		return nil, fmt.Errorf("unknown action %q", action)
+	}
+	return factory(params)
+}
@@ -0,0 +1,65 @@
+package runner
+
+import (
+	"os"
+	"time"
+)
+
+// Result is the outcome of an action execution.
+type Result struct {
+	// Stdout is everything the action wrote to its standard output.
+	Stdout string
+	// Stderr is everything the action wrote to its standard error.
+	Stderr string
+	// ExitCode is the exit code of the process, or -1 if it did not exit on
+	// its own (for instance because it was killed by a signal).
+	ExitCode int
+	// Signal names the signal that terminated the process, if any.
+	Signal string
+	// StartTime and EndTime delimit the execution.
+	StartTime time.Time
+	EndTime   time.Time
+	// UserTime and SystemTime are the CPU time used by the process.
+	UserTime   time.Duration
+	SystemTime time.Duration
+	// MaxRSS is the peak resident set size of the process in kilobytes, when
+	// the platform reports it.
+	MaxRSS int64
+	// Outputs are named values reported by the action.
+	Outputs map[string]string
+	// Tasks are the tasks run by the action, in order, for actions running a
+	// whole workflow.
+	Tasks []TaskStatus
+}
+
+// TaskStatus is the final status of a task run by an action.
+type TaskStatus struct {
+	Name     string
+	Status   string
+	Duration time.Duration
+	// Tasks are the tasks the task itself ran, if any.
+	Tasks []TaskStatus
+}
+
+// Duration returns how long the execution took.
+func (r *Result) Duration() time.Duration {
+	return r.EndTime.Sub(r.StartTime)
+}
+
+// Output returns stdout followed by stderr.
+func (r *Result) Output() string {
+	return r.Stdout + r.Stderr
+}
+
+// setProcessState fills the exit status and resource usage of a finished
+// process.
+func (r *Result) setProcessState(state *os.ProcessState) {
+	if state == nil {
+		r.ExitCode = -1
+		return
+	}
+	r.ExitCode = state.ExitCode()
+	r.UserTime = state.UserTime()
+	r.SystemTime = state.SystemTime()
+	setSysUsage(r, state)
+}
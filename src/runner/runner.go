@@ -1,15 +1,23 @@
-// Package runner executes tasks on the system using the os/exec package.
+// Package runner executes task actions. Actions are looked up by name in a
+// registry; the built-in "process" action runs a command using the os/exec
+// package.
 package runner
 
 import (
+	"context"
 	"fmt"
+	"gotasker/src/workflow"
 	"os/exec"
+	"sort"
+	"time"
 )
 
-// Runner is an interface that requires an Execute method.
+// Runner is the interface implemented by every action.
 type Runner interface {
-	// Execute runs the task and returns the output or an error if any.
-	Execute() (string, error)
+	// ExecuteContext runs the action and returns its result and an error if
+	// any. The result is also returned on failure whenever the action got to
+	// run. It must stop the action when ctx is done.
+	ExecuteContext(ctx context.Context) (*Result, error)
 }
 
 // Execution represents a task to be executed.
@@ -28,43 +36,119 @@ func NewExecution(name string, parameters map[string]interface{}) *Execution {
 	}
 }
 
-// Execute runs the task with its parameters. It returns an error if the execution fails.
-func (e *Execution) Execute() (string, error) {
-	var args []string
+// Execute runs the task with its parameters. It returns an error if the execution fails,
+// wrapping the *exec.ExitError when the process exited with a non-zero status.
+func (e *Execution) Execute() (*Result, error) {
+	return e.ExecuteContext(context.Background())
+}
 
+// ExecuteContext runs the task with its parameters in its own process group,
+// in the directory and with the environment described by the dir, env,
+// env-file and inherit-env params.
+// If ctx is done before the process exits, the whole group is terminated as
+// described by the KillOptions carried by ctx, and the returned error wraps
+// ctx.Err() so callers can tell a timeout or an abort from a regular failure.
+// The output is also streamed to the writers set with WithOutput, if any.
+func (e *Execution) ExecuteContext(ctx context.Context) (*Result, error) {
 	// Determine the command binary: prefer "path" from params, fallback to CommandName.
 	cmdBinary := e.CommandName
 	if path, ok := e.CommandParams["path"].(string); ok && path != "" {
 		cmdBinary = path
 	}
 
-	if rawArgs, ok := e.CommandParams["args"]; ok && rawArgs != nil {
-		if argList, ok := rawArgs.([]interface{}); ok {
-			for _, arg := range argList {
-				switch v := arg.(type) {
-				case string:
-					args = append(args, v)
-				case map[string]interface{}:
-					for key, value := range v {
-						switch val := value.(type) {
-						case []interface{}:
-							for _, argvalue := range val {
-								args = append(args, fmt.Sprintf("--%s=%v", key, argvalue))
-							}
-						default:
-							args = append(args, fmt.Sprintf("--%s=%v", key, val))
-						}
-					}
-				}
+	args, err := buildArgs(e.CommandParams)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing process task: %w", err)
+	}
+	cmd := exec.Command(cmdBinary, args...)
+	if err := setCommandEnv(cmd, e.CommandParams); err != nil {
+		return nil, fmt.Errorf("error preparing process task: %w", err)
+	}
+	result, err := run(ctx, cmd)
+	if err != nil {
+		// Keep whatever the process printed, it usually explains the failure.
+		return result, fmt.Errorf("error executing process task: %w", err)
+	}
+	return result, nil
+}
+
+// buildArgs renders the args param as command line arguments. Strings are
+// passed as they are and map entries render as flags in the style set by the
+// flag-style param, booleans as set by the bool-flags param. Entries of a map with several keys are rendered in key
+// order; the workflow loader splits such maps so their entries keep the order
+// of the source file.
+func buildArgs(params map[string]interface{}) ([]string, error) {
+	style, _ := params["flag-style"].(string)
+	if !workflow.IsValidFlagStyle(style) {
+		return nil, fmt.Errorf("unknown flag-style %q (use equals, space or short)", style)
+	}
+	boolFlags, _ := params["bool-flags"].(string)
+	if !workflow.IsValidBoolFlags(boolFlags) {
+		return nil, fmt.Errorf("unknown bool-flags %q (use value or bare)", boolFlags)
+	}
+	bare := boolFlags == workflow.BoolFlagsBare
+	var args []string
+	argList, _ := params["args"].([]interface{})
+	for _, arg := range argList {
+		switch v := arg.(type) {
+		case string:
+			args = append(args, v)
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				args = appendFlag(args, style, bare, key, v[key])
 			}
 		}
 	}
+	return args, nil
+}
 
-	cmd := exec.Command(cmdBinary, args...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("error executing process task: %v", err)
+// appendFlag appends the flag rendering one map entry of the args param. List
+// values repeat the flag and a key without value renders a bare flag. With
+// bare, true also renders a bare flag and false omits it.
+func appendFlag(args []string, style string, bare bool, key string, value interface{}) []string {
+	name := "--" + key
+	if style == workflow.FlagStyleShort {
+		name = "-" + key
 	}
+	switch v := value.(type) {
+	case nil:
+		return append(args, name)
+	case bool:
+		if bare && v {
+			return append(args, name)
+		} else if bare {
+			return args
+		}
+	case []interface{}:
+		for _, item := range v {
+			args = appendFlag(args, style, bare, key, item)
+		}
+		return args
+	}
+	if style == workflow.FlagStyleSpace || style == workflow.FlagStyleShort {
+		return append(args, name, fmt.Sprint(value))
+	}
+	return append(args, fmt.Sprintf("%s=%v", name, value))
+}
+
+// run executes cmd with runCommand, capturing its stdout and stderr while
+// streaming them to the writers set with WithOutput, and returns its result.
+func run(ctx context.Context, cmd *exec.Cmd) (*Result, error) {
+	var stdout, stderr syncBuffer
+	liveStdout, liveStderr := OutputFrom(ctx)
+	cmd.Stdout = Tee(&stdout, liveStdout)
+	cmd.Stderr = Tee(&stderr, liveStderr)
 
-	return string(output[:]), nil
+	result := &Result{StartTime: time.Now()}
+	err := runCommand(ctx, cmd)
+	result.EndTime = time.Now()
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	result.setProcessState(cmd.ProcessState)
+	return result, err
 }
@@ -0,0 +1,110 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ShellAction is the name of the built-in action running a script.
+const ShellAction = "shell"
+
+// DefaultShell is the interpreter of the shell action when none is given.
+const DefaultShell = "sh"
+
+func init() {
+	Register(ShellAction, func(params map[string]interface{}) (Runner, error) {
+		script, _ := params["script"].(string)
+		if script == "" {
+			return nil, fmt.Errorf("the shell action requires a script")
+		}
+		interpreter := DefaultShell
+		if shell, ok := params["shell"].(string); ok && shell != "" {
+			interpreter = shell
+		}
+		strict := true
+		if value, ok := params["strict"]; ok && value != nil {
+			b, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("strict must be true or false, got %v", value)
+			}
+			strict = b
+		}
+		return &Shell{Script: script, Interpreter: interpreter, Strict: strict, Params: params}, nil
+	})
+}
+
+// Shell runs a script with an interpreter, so tasks can use pipes, redirects,
+// conditionals and multi-line bodies.
+type Shell struct {
+	// Script is the body of the script.
+	Script string
+	// Interpreter is the command running the script, optionally followed by
+	// its own arguments, such as "bash" or "python3 -u".
+	Interpreter string
+	// Strict makes POSIX shells exit on the first failing command and on
+	// unset variables, and bash and zsh also on failures inside pipelines.
+	// Other interpreters are not affected.
+	Strict bool
+	// Params are the remaining parameters: args are passed to the script
+	// and dir, env, env-file and inherit-env apply as for the process action.
+	Params map[string]interface{}
+}
+
+// ExecuteContext writes the script to a temporary file, so error messages
+// refer to its own line numbers, and runs it like the process action.
+func (s *Shell) ExecuteContext(ctx context.Context) (*Result, error) {
+	fields := strings.Fields(s.Interpreter)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("the shell action requires an interpreter")
+	}
+
+	file, err := os.CreateTemp("", "gotasker-script-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating script file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(s.Script)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error writing script file: %w", err)
+	}
+
+	scriptArgs, err := buildArgs(s.Params)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing shell script: %w", err)
+	}
+	args := append(fields[1:], s.strictFlags()...)
+	args = append(args, file.Name())
+	args = append(args, scriptArgs...)
+	cmd := exec.Command(fields[0], args...)
+	if err := setCommandEnv(cmd, s.Params); err != nil {
+		return nil, fmt.Errorf("error preparing shell script: %w", err)
+	}
+	result, err := run(ctx, cmd)
+	if err != nil {
+		return result, fmt.Errorf("error executing shell script: %w", err)
+	}
+	return result, nil
+}
+
+// strictFlags returns the interpreter options enabling strict mode. They are
+// given on the command line rather than prepended to the script so line
+// numbers are left untouched.
+func (s *Shell) strictFlags() []string {
+	if !s.Strict {
+		return nil
+	}
+	switch filepath.Base(strings.Fields(s.Interpreter)[0]) {
+	case "bash", "zsh", "ksh":
+		return []string{"-e", "-u", "-o", "pipefail"}
+	case "sh", "dash", "ash":
+		return []string{"-e", "-u"}
+	}
+	return nil
+}
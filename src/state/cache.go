@@ -0,0 +1,86 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fingerprint is the state of a task at its last successful run.
+type Fingerprint struct {
+	// Hash covers the rendered action, its environment and the content of
+	// its inputs.
+	Hash    string            `json:"hash"`
+	Outputs map[string]string `json:"outputs,omitempty"`
+	Updated time.Time         `json:"updated"`
+}
+
+// Cache keeps the fingerprints of the tasks of a workflow on disk.
+type Cache struct {
+	mu    sync.Mutex
+	path  string
+	tasks map[string]Fingerprint
+}
+
+// CachePath returns the fingerprint file of a workflow, next to its
+// checkpoint.
+func CachePath(workflowPath string, stateDir string) (string, error) {
+	path, err := Path(workflowPath, stateDir)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(path, ".json") + ".cache.json", nil
+}
+
+// LoadCache reads the fingerprints of a workflow. A workflow that never ran
+// gets an empty cache.
+func LoadCache(workflowPath string, stateDir string) (*Cache, error) {
+	path, err := CachePath(workflowPath, stateDir)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cache{path: path, tasks: make(map[string]Fingerprint)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading cache: %w", err)
+	}
+	if err := json.Unmarshal(data, &c.tasks); err != nil {
+		return nil, fmt.Errorf("error parsing cache %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Get returns the fingerprint of the last successful run of a task.
+func (c *Cache) Get(taskName string) (Fingerprint, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fingerprint, ok := c.tasks[taskName]
+	return fingerprint, ok
+}
+
+// Put records the fingerprint of a successful run of a task, with its
+// outputs, and writes the cache to disk.
+func (c *Cache) Put(taskName string, hash string, outputs map[string]string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tasks[taskName] = Fingerprint{Hash: hash, Outputs: outputs, Updated: time.Now()}
+	return writeJSON(c.path, c.tasks)
+}
+
+// Delete forgets the fingerprint of a task, so it runs the next time.
+func (c *Cache) Delete(taskName string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.tasks[taskName]; !ok {
+		return nil
+	}
+	delete(c.tasks, taskName)
+	return writeJSON(c.path, c.tasks)
+}
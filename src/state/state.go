@@ -0,0 +1,182 @@
+// Package state persists the progress of workflow runs so interrupted or
+// failed runs can be resumed, and the fingerprints of tasks so unchanged ones
+// can be skipped. Both live in a .gotasker directory next to the workflow
+// file, or in a given state directory, in files named after a hash of the
+// absolute workflow path. The path, not the content, keys them so a resume
+// still finds the checkpoint of a workflow edited since; the checkpoint
+// records the hash of the content to tell.
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Dir is the name of the directory holding the state files, next to the
+// workflow file unless a state directory is given.
+const Dir = ".gotasker"
+
+// TaskState is the last recorded state of a task.
+type TaskState struct {
+	Status  string            `json:"status"`
+	Outputs map[string]string `json:"outputs,omitempty"`
+	Updated time.Time         `json:"updated"`
+}
+
+// Checkpoint is the recorded progress of a workflow run.
+type Checkpoint struct {
+	// Workflow is the absolute path of the workflow file.
+	Workflow string `json:"workflow"`
+	// Hash is the hash of the workflow file content when the run started, or
+	// when a resume last found it changed.
+	Hash    string               `json:"hash"`
+	Started time.Time            `json:"started"`
+	Tasks   map[string]TaskState `json:"tasks"`
+}
+
+// Store keeps the checkpoint of a workflow run on disk.
+type Store struct {
+	mu         sync.Mutex
+	path       string
+	checkpoint Checkpoint
+}
+
+// Path returns the checkpoint file of a workflow, in stateDir, or in the Dir
+// directory next to the workflow when stateDir is empty.
+func Path(workflowPath string, stateDir string) (string, error) {
+	abs, err := filepath.Abs(workflowPath)
+	if err != nil {
+		return "", err
+	}
+	if stateDir == "" {
+		stateDir = filepath.Join(filepath.Dir(abs), Dir)
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(stateDir, hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// HashFile returns the hash of the content of a file.
+func HashFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// New starts an empty checkpoint for a new run of a workflow, replacing the
+// previous one once the first status is recorded.
+func New(workflowPath string, stateDir string) (*Store, error) {
+	path, err := Path(workflowPath, stateDir)
+	if err != nil {
+		return nil, err
+	}
+	abs, _ := filepath.Abs(workflowPath)
+	hash, err := HashFile(workflowPath)
+	if err != nil {
+		return nil, fmt.Errorf("error hashing workflow: %w", err)
+	}
+	return &Store{
+		path: path,
+		checkpoint: Checkpoint{
+			Workflow: abs,
+			Hash:     hash,
+			Started:  time.Now(),
+			Tasks:    make(map[string]TaskState),
+		},
+	}, nil
+}
+
+// Load reads the checkpoint of the last run of a workflow. The returned
+// error wraps os.ErrNotExist when the workflow has no checkpoint.
+func Load(workflowPath string, stateDir string) (*Store, error) {
+	path, err := Path(workflowPath, stateDir)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint: %w", err)
+	}
+	s := &Store{path: path}
+	if err := json.Unmarshal(data, &s.checkpoint); err != nil {
+		return nil, fmt.Errorf("error parsing checkpoint %s: %w", path, err)
+	}
+	if s.checkpoint.Tasks == nil {
+		s.checkpoint.Tasks = make(map[string]TaskState)
+	}
+	return s, nil
+}
+
+// Checkpoint returns a copy of the recorded progress.
+func (s *Store) Checkpoint() Checkpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.checkpoint
+	c.Tasks = make(map[string]TaskState, len(s.checkpoint.Tasks))
+	for k, v := range s.checkpoint.Tasks {
+		c.Tasks[k] = v
+	}
+	return c
+}
+
+// Changed reports whether the workflow file changed since the checkpoint was
+// started.
+func (s *Store) Changed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash, err := HashFile(s.checkpoint.Workflow)
+	return err != nil || hash != s.checkpoint.Hash
+}
+
+// UpdateHash records the current hash of the workflow file and writes the
+// checkpoint to disk, so once a resume has warned that the workflow changed,
+// the next ones compare against the new content.
+func (s *Store) UpdateHash() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash, err := HashFile(s.checkpoint.Workflow)
+	if err != nil {
+		return fmt.Errorf("error hashing workflow: %w", err)
+	}
+	s.checkpoint.Hash = hash
+	return s.saveLocked()
+}
+
+// Record stores the new status of a task, with its outputs, and writes the
+// checkpoint to disk.
+func (s *Store) Record(taskName string, status string, outputs map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoint.Tasks[taskName] = TaskState{Status: status, Outputs: outputs, Updated: time.Now()}
+	return s.saveLocked()
+}
+
+// saveLocked writes the checkpoint to disk. Must be called with s.mu held.
+func (s *Store) saveLocked() error {
+	return writeJSON(s.path, s.checkpoint)
+}
+
+// writeJSON writes a value as JSON atomically, so an interrupted write never
+// leaves a truncated file, creating the state directory if needed.
+func writeJSON(path string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating state directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return os.Rename(tmp, path)
+}
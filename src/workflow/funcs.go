@@ -0,0 +1,148 @@
+package workflow
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateFuncs are the functions available to the templates of a workflow,
+// in task fields and variable definitions alike. They take the value they
+// work on last, so they can end a pipeline: {{.name | replace "-" "_"}}.
+// The built-in functions of text/template, such as index, len and printf,
+// are available as well.
+var templateFuncs = template.FuncMap{
+	// Strings.
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"trim":    strings.TrimSpace,
+	"replace": func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"split":   func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":    join,
+	"default": defaultValue,
+
+	// Environment and time.
+	"env":  os.Getenv,
+	"now":  time.Now,
+	"date": date,
+
+	// Encodings.
+	"sha256":       func(s string) string { sum := sha256.Sum256([]byte(s)); return hex.EncodeToString(sum[:]) },
+	"base64":       func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"base64Decode": base64Decode,
+	"toJson":       toJSON,
+	"fromJson":     fromJSON,
+
+	// Paths and files.
+	"base":     filepath.Base,
+	"dir":      filepath.Dir,
+	"ext":      filepath.Ext,
+	"clean":    filepath.Clean,
+	"joinPath": filepath.Join,
+	"readFile": readFile,
+
+	// Lists.
+	"first": first,
+	"last":  last,
+}
+
+// newTemplate returns an empty template with the workflow functions.
+func newTemplate(name string) *template.Template {
+	return template.New(name).Funcs(templateFuncs)
+}
+
+// join joins the items of a list, printed like fmt.Sprint does, with sep.
+func join(sep string, list interface{}) (string, error) {
+	items, err := listItems(list)
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, len(items))
+	for i, item := range items {
+		parts[i] = fmt.Sprint(item)
+	}
+	return strings.Join(parts, sep), nil
+}
+
+// defaultValue returns value, or fallback if value is empty: nil, false,
+// zero, or an empty string, list or map.
+func defaultValue(fallback interface{}, value interface{}) interface{} {
+	if value == nil {
+		return fallback
+	}
+	if v := reflect.ValueOf(value); v.IsZero() || (v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.Len() == 0 {
+		return fallback
+	}
+	return value
+}
+
+// date formats a time with a Go layout, such as "2006-01-02".
+func date(layout string, t time.Time) string {
+	return t.Format(layout)
+}
+
+// base64Decode decodes a standard base64 string.
+func base64Decode(s string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	return string(decoded), err
+}
+
+// toJSON encodes a value as JSON.
+func toJSON(value interface{}) (string, error) {
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
+
+// fromJSON decodes a JSON document, so its fields can be read with index or
+// the dot notation.
+func fromJSON(s string) (interface{}, error) {
+	var value interface{}
+	err := json.Unmarshal([]byte(s), &value)
+	return value, err
+}
+
+// readFile returns the content of a file, relative to the directory gotasker
+// runs in.
+func readFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	return string(content), err
+}
+
+// first returns the first item of a list.
+func first(list interface{}) (interface{}, error) {
+	items, err := listItems(list)
+	if err != nil || len(items) == 0 {
+		return nil, fmt.Errorf("first of an empty list or not a list: %v", list)
+	}
+	return items[0], nil
+}
+
+// last returns the last item of a list.
+func last(list interface{}) (interface{}, error) {
+	items, err := listItems(list)
+	if err != nil || len(items) == 0 {
+		return nil, fmt.Errorf("last of an empty list or not a list: %v", list)
+	}
+	return items[len(items)-1], nil
+}
+
+// listItems returns the items of a slice or array of any type.
+func listItems(list interface{}) ([]interface{}, error) {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("%v is not a list", list)
+	}
+	items := make([]interface{}, v.Len())
+	for i := range items {
+		items[i] = v.Index(i).Interface()
+	}
+	return items, nil
+}
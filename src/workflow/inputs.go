@@ -0,0 +1,256 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Types of workflow inputs.
+const (
+	InputString = "string"
+	InputInt    = "int"
+	InputBool   = "bool"
+	InputList   = "list"
+	InputEnum   = "enum"
+)
+
+// Input declares a parameter of a workflow. Its value is given like any
+// variable override, with -var, -var-file or GOTASKER_VAR_, and checked
+// against the declaration before the workflow is expanded. The value then
+// becomes the variable of the same name.
+type Input struct {
+	Name string `json:"-"`
+	// Type is string (the default), int, bool, list or enum.
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	// Default is the value of an input that is not given, an empty value of
+	// its type otherwise.
+	Default interface{} `json:"default"`
+	// Required inputs must be given, unless they have a default.
+	Required bool `json:"required"`
+	// Values are the allowed values of an enum.
+	Values []interface{} `json:"values"`
+	// Pattern is a regular expression the value must match, or each item of
+	// a list.
+	Pattern string `json:"pattern"`
+}
+
+// parseInputs decodes the inputs section of a workflow, sorted by name, and
+// checks the declarations.
+func parseInputs(raw interface{}) ([]Input, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	declarations, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("inputs must be a map of input names to declarations")
+	}
+	inputs := make([]Input, 0, len(declarations))
+	var errs []error
+	for name, declaration := range declarations {
+		input := Input{Name: name}
+		if declaration != nil {
+			encoded, err := json.Marshal(declaration)
+			if err == nil {
+				err = json.Unmarshal(encoded, &input)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("input %s: %w", name, err))
+				continue
+			}
+		}
+		if err := input.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("input %s: %w", name, err))
+			continue
+		}
+		inputs = append(inputs, input)
+	}
+	sort.Slice(inputs, func(i, j int) bool { return inputs[i].Name < inputs[j].Name })
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return inputs, errors.Join(errs...)
+}
+
+// validate checks the declaration of an input.
+func (in *Input) validate() error {
+	switch in.Type {
+	case "":
+		in.Type = InputString
+	case InputString, InputInt, InputBool, InputList:
+	case InputEnum:
+		if len(in.Values) == 0 {
+			return fmt.Errorf("an enum needs values")
+		}
+	default:
+		return fmt.Errorf("unknown type %q (use string, int, bool, list or enum)", in.Type)
+	}
+	if in.Type != InputEnum && len(in.Values) > 0 {
+		return fmt.Errorf("values are only allowed for enums")
+	}
+	if in.Pattern != "" {
+		if _, err := regexp.Compile(in.Pattern); err != nil {
+			return fmt.Errorf("pattern: %w", err)
+		}
+	}
+	if in.Default != nil {
+		if _, err := in.Check(in.Default); err != nil {
+			return fmt.Errorf("default: %w", err)
+		}
+	}
+	return nil
+}
+
+// Check returns a value given for the input, converted to its type, or an
+// error if it is not a valid value. Numbers and booleans given as strings,
+// as in JSON var files, are converted.
+func (in Input) Check(value interface{}) (interface{}, error) {
+	var converted interface{}
+	switch in.Type {
+	case InputInt:
+		switch v := value.(type) {
+		case int:
+			converted = v
+		case float64:
+			if v != math.Trunc(v) {
+				return nil, fmt.Errorf("%v is not an int", v)
+			}
+			converted = int(v)
+		case string:
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not an int", v)
+			}
+			converted = n
+		default:
+			return nil, fmt.Errorf("%v is not an int", value)
+		}
+	case InputBool:
+		switch v := value.(type) {
+		case bool:
+			converted = v
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a bool", v)
+			}
+			converted = b
+		default:
+			return nil, fmt.Errorf("%v is not a bool", value)
+		}
+	case InputList:
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%v is not a list", value)
+		}
+		converted = list
+	case InputEnum:
+		for _, allowed := range in.Values {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				converted = allowed
+			}
+		}
+		if converted == nil {
+			return nil, fmt.Errorf("%v is not one of %s", value, in.choices())
+		}
+	default:
+		switch value.(type) {
+		case []interface{}, map[string]interface{}:
+			return nil, fmt.Errorf("%v is not a string", value)
+		}
+		converted = fmt.Sprint(value)
+	}
+
+	if in.Pattern != "" {
+		pattern := regexp.MustCompile(in.Pattern)
+		items := []interface{}{converted}
+		if list, ok := converted.([]interface{}); ok {
+			items = list
+		}
+		for _, item := range items {
+			if !pattern.MatchString(fmt.Sprint(item)) {
+				return nil, fmt.Errorf("%q does not match %s", fmt.Sprint(item), in.Pattern)
+			}
+		}
+	}
+	return converted, nil
+}
+
+// Usage describes the values of the input, such as "int" or
+// "dev|staging|prod".
+func (in Input) Usage() string {
+	if in.Type == InputEnum {
+		return in.choices()
+	}
+	return in.Type
+}
+
+// choices lists the values of an enum.
+func (in Input) choices() string {
+	values := make([]string, len(in.Values))
+	for i, value := range in.Values {
+		values[i] = fmt.Sprint(value)
+	}
+	return strings.Join(values, "|")
+}
+
+// defaultValue returns the value of an input that is not given: its default,
+// or else an empty value of its type, so templates can still read it.
+func (in Input) defaultValue() (interface{}, error) {
+	if in.Default != nil {
+		return in.Check(in.Default)
+	}
+	switch in.Type {
+	case InputInt:
+		return 0, nil
+	case InputBool:
+		return false, nil
+	case InputList:
+		return []interface{}{}, nil
+	}
+	return "", nil
+}
+
+// resolveInputs returns the values of the inputs from the given variables,
+// or their defaults, reporting every missing or invalid value at once. It
+// also returns the names of the inputs left to their default.
+func resolveInputs(inputs []Input, given map[string]interface{}) (map[string]interface{}, map[string]struct{}, error) {
+	values := make(map[string]interface{}, len(inputs))
+	defaulted := make(map[string]struct{})
+	var errs []error
+	for _, input := range inputs {
+		value, ok := given[input.Name]
+		if !ok {
+			if input.Required && input.Default == nil {
+				errs = append(errs, fmt.Errorf("input %s is required: set it with -var %s=<%s>", input.Name, input.Name, input.Usage()))
+				continue
+			}
+			values[input.Name], _ = input.defaultValue()
+			defaulted[input.Name] = struct{}{}
+			continue
+		}
+		converted, err := input.Check(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("input %s: %w", input.Name, err))
+			continue
+		}
+		values[input.Name] = converted
+	}
+	return values, defaulted, errors.Join(errs...)
+}
+
+// ReadInputs returns the description and the inputs of a workflow file,
+// without loading the rest of it, to show how to run it.
+func ReadInputs(path string) (string, []Input, error) {
+	workflowData, err := loadWorkflowFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	description, _ := workflowData["description"].(string)
+	inputs, err := parseInputs(workflowData["inputs"])
+	return description, inputs, err
+}
@@ -0,0 +1,189 @@
+package workflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Sources an output can be captured from.
+const (
+	// OutputFromStdout takes the whole stdout of the task, trimmed.
+	OutputFromStdout = "stdout"
+	// OutputFromRegex takes the first match of a pattern over stdout, or
+	// its first capture group if it has one.
+	OutputFromRegex = "regex"
+	// OutputFromJSON parses stdout as JSON and takes the value at a path.
+	OutputFromJSON = "json"
+	// OutputFromFile takes a key of the file named by $GOTASKER_OUTPUT.
+	OutputFromFile = "file"
+)
+
+// OutputFileEnv is the environment variable naming the file in which a task
+// can write KEY=VALUE lines that become its outputs.
+const OutputFileEnv = "GOTASKER_OUTPUT"
+
+// Output declares a value captured from a task once it has succeeded. Its
+// dependents read it as {{.tasks.<task>.outputs.<name>}}. An output given as
+// a plain string is instead a file the task produces.
+type Output struct {
+	// Path is the file of a file output.
+	Path string `json:"path,omitempty"`
+	Name string `json:"name,omitempty"`
+	// From is stdout (the default), regex, json or file.
+	From string `json:"from,omitempty"`
+	// Pattern is the regular expression of the regex source.
+	Pattern string `json:"pattern,omitempty"`
+	// Query is the path of the json source, such as .artifact.id or
+	// .items[0].name.
+	Query string `json:"query,omitempty"`
+	// Key is the key of the file source; it defaults to Name.
+	Key string `json:"key,omitempty"`
+}
+
+// UnmarshalJSON reads a string as a file output and an object as a value.
+func (o *Output) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*o = Output{Path: path}
+		return nil
+	}
+	type plain Output
+	return json.Unmarshal(data, (*plain)(o))
+}
+
+// validate checks that the output is complete, that it only sets the fields
+// of its source and that its pattern compiles.
+func (o Output) validate() error {
+	if o.Path != "" {
+		if o.Name != "" || o.From != "" || o.Pattern != "" || o.Query != "" || o.Key != "" {
+			return fmt.Errorf("output %s: an output is either a file path or a named value", o.Path)
+		}
+		return nil
+	}
+	if o.Name == "" {
+		return fmt.Errorf("outputs need a name")
+	}
+	for _, field := range []struct {
+		name   string
+		set    bool
+		source string
+	}{
+		{"pattern", o.Pattern != "", OutputFromRegex},
+		{"query", o.Query != "", OutputFromJSON},
+		{"key", o.Key != "", OutputFromFile},
+	} {
+		if field.set && o.From != field.source {
+			from := o.From
+			if from == "" {
+				from = OutputFromStdout
+			}
+			return fmt.Errorf("output %s: %s only applies to the %s source, not %s", o.Name, field.name, field.source, from)
+		}
+	}
+	switch o.From {
+	case "", OutputFromStdout, OutputFromFile:
+	case OutputFromRegex:
+		if o.Pattern == "" {
+			return fmt.Errorf("output %s: the regex source requires a pattern", o.Name)
+		}
+		if _, err := regexp.Compile(o.Pattern); err != nil {
+			return fmt.Errorf("output %s: %w", o.Name, err)
+		}
+	case OutputFromJSON:
+		if o.Query == "" {
+			return fmt.Errorf("output %s: the json source requires a query", o.Name)
+		}
+	default:
+		return fmt.Errorf("output %s: unknown source %q (use stdout, regex, json or file)", o.Name, o.From)
+	}
+	return nil
+}
+
+// Extract captures the output from the stdout of the task and the values it
+// wrote to its output file.
+func (o Output) Extract(stdout string, fileValues map[string]string) (string, error) {
+	switch o.From {
+	case "", OutputFromStdout:
+		return strings.TrimSpace(stdout), nil
+	case OutputFromRegex:
+		pattern, err := regexp.Compile(o.Pattern)
+		if err != nil {
+			return "", err
+		}
+		match := pattern.FindStringSubmatch(stdout)
+		if match == nil {
+			return "", fmt.Errorf("output %s: pattern %q does not match stdout", o.Name, o.Pattern)
+		}
+		if len(match) > 1 {
+			return match[1], nil
+		}
+		return match[0], nil
+	case OutputFromJSON:
+		value, err := jsonQuery(stdout, o.Query)
+		if err != nil {
+			return "", fmt.Errorf("output %s: %w", o.Name, err)
+		}
+		return value, nil
+	case OutputFromFile:
+		key := o.Key
+		if key == "" {
+			key = o.Name
+		}
+		value, ok := fileValues[key]
+		if !ok {
+			return "", fmt.Errorf("output %s: key %s was not written to $%s", o.Name, key, OutputFileEnv)
+		}
+		return value, nil
+	}
+	return "", fmt.Errorf("output %s: unknown source %q", o.Name, o.From)
+}
+
+// jsonQuery parses document as JSON and returns the value at the given path.
+// Strings are returned as they are and other values as JSON.
+func jsonQuery(document string, query string) (string, error) {
+	dec := json.NewDecoder(strings.NewReader(document))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return "", fmt.Errorf("stdout is not JSON: %w", err)
+	}
+
+	path := strings.ReplaceAll(strings.TrimPrefix(query, "."), "[", ".")
+	for _, segment := range strings.Split(path, ".") {
+		segment = strings.TrimSuffix(segment, "]")
+		if segment == "" {
+			continue
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[segment]
+			if !ok {
+				return "", fmt.Errorf("query %s: no key %q", query, segment)
+			}
+			value = next
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", fmt.Errorf("query %s: no index %q", query, segment)
+			}
+			value = v[i]
+		default:
+			return "", fmt.Errorf("query %s: cannot look up %q in %v", query, segment, v)
+		}
+	}
+
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
@@ -0,0 +1,110 @@
+package workflow
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"time"
+)
+
+// MaxBackoff caps the exponential backoff, unless the retry delay itself is
+// longer, so the doubled delay neither grows without bound nor overflows.
+const MaxBackoff = time.Hour
+
+// RetryPolicy describes how a failed task is retried.
+type RetryPolicy struct {
+	// Retries is the number of extra attempts after the first one.
+	Retries int
+	// Delay is the wait before the first retry.
+	Delay time.Duration
+	// Exponential doubles the delay after every failed attempt.
+	Exponential bool
+	// Jitter randomizes each delay between half and all of its value.
+	Jitter bool
+	// ExitCodes restricts retries to these exit codes.
+	ExitCodes map[int]struct{}
+	// Patterns restricts retries to attempts whose output matches one of them.
+	Patterns []*regexp.Regexp
+}
+
+// RetryPolicy parses the retry settings of the task.
+func (t *Task) RetryPolicy() (*RetryPolicy, error) {
+	if t.Retries < 0 {
+		return nil, fmt.Errorf("retries must not be negative")
+	}
+	delay, err := ParseTimeout(t.RetryDelay)
+	if err != nil {
+		return nil, fmt.Errorf("retry-delay: %w", err)
+	}
+	policy := &RetryPolicy{
+		Retries:   t.Retries,
+		Delay:     delay,
+		Jitter:    t.RetryJitter,
+		ExitCodes: make(map[int]struct{}),
+	}
+
+	switch t.Backoff {
+	case "", "fixed":
+	case "exponential":
+		policy.Exponential = true
+	default:
+		return nil, fmt.Errorf("unknown backoff %q (use fixed or exponential)", t.Backoff)
+	}
+
+	for _, condition := range t.RetryOn {
+		switch c := condition.(type) {
+		case float64:
+			if c != float64(int(c)) {
+				return nil, fmt.Errorf("retry-on exit code %v is not an integer", c)
+			}
+			policy.ExitCodes[int(c)] = struct{}{}
+		case int:
+			policy.ExitCodes[c] = struct{}{}
+		case string:
+			pattern, err := regexp.Compile(c)
+			if err != nil {
+				return nil, fmt.Errorf("retry-on pattern %q: %w", c, err)
+			}
+			policy.Patterns = append(policy.Patterns, pattern)
+		default:
+			return nil, fmt.Errorf("retry-on entries must be exit codes or patterns, got %v", c)
+		}
+	}
+	return policy, nil
+}
+
+// ShouldRetry reports whether an attempt that failed with the given exit code
+// and output qualifies for a retry. Without retry-on conditions every failure
+// does. An exit code of -1 means the process did not exit normally.
+func (p *RetryPolicy) ShouldRetry(exitCode int, output string) bool {
+	if len(p.ExitCodes) == 0 && len(p.Patterns) == 0 {
+		return true
+	}
+	if _, ok := p.ExitCodes[exitCode]; ok {
+		return true
+	}
+	for _, pattern := range p.Patterns {
+		if pattern.MatchString(output) {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns the wait before the retry that follows the given failed
+// attempt, counting attempts from 1.
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.Delay
+	if p.Exponential {
+		limit := max(MaxBackoff, p.Delay)
+		for i := 1; i < attempt && delay > 0 && delay < limit; i++ {
+			delay *= 2
+		}
+		delay = min(delay, limit)
+	}
+	if p.Jitter && delay > 0 {
+		half := delay / 2
+		delay = half + time.Duration(rand.Int63n(int64(delay-half)+1))
+	}
+	return delay
+}
@@ -0,0 +1,1188 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template/parse"
+
+	yamlv2 "gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is an issue found in a workflow file by Validate.
+type Problem struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+// String formats the problem as file:line:column: message, leaving out the
+// parts of the position that are unknown.
+func (p Problem) String() string {
+	switch {
+	case p.Line == 0:
+		return fmt.Sprintf("%s: %s", p.File, p.Message)
+	case p.Column == 0:
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", p.File, p.Line, p.Column, p.Message)
+}
+
+// Validate checks a workflow file and the files it imports without running
+// anything, and returns every problem found, sorted by file and position:
+// syntax errors, unknown keys, values of the wrong type or outside their
+// allowed set, unknown actions, templates that do not parse or read undefined
+// variables, foreach loops over undefined variables, invalid inputs or input
+// values given in opts, duplicate task names, dependencies on missing tasks,
+// dependency cycles and .tasks references to tasks that are not dependencies.
+// Undefined variables are allowed in workflows that are not strict, or with
+// opts.Lenient.
+func Validate(path string, opts Options) []Problem {
+	v := &validator{actionCheck: opts.CheckAction, conditionCheck: opts.CheckCondition}
+	main := v.parse(path, "", nil)
+	if main == nil {
+		return v.problems
+	}
+	v.checkWorkflow(main)
+	inputs := v.checkInputs(main, opts)
+	files := []*sourceFile{main}
+	if imports := lookup(main.root, "imports"); imports != nil && imports.Kind == yaml.SequenceNode {
+		for _, entry := range imports.Content {
+			file, as := scalar(lookup(entry, "file")), scalar(lookup(entry, "as"))
+			if file == "" || as == "" {
+				continue
+			}
+			importPath := file
+			if !filepath.IsAbs(importPath) {
+				importPath = filepath.Join(filepath.Dir(path), importPath)
+			}
+			imported := v.parse(importPath, main.path, lookup(entry, "file"))
+			if imported == nil {
+				continue
+			}
+			imported.namespace = as
+			v.checkWorkflow(imported)
+			files = append(files, imported)
+		}
+	}
+
+	// Imported variables only fill in those the main workflow leaves out.
+	v.variables = decodeVariables(main.root)
+	overrides := map[string]interface{}{"variables": v.variables}
+	overrideVariables(overrides, opts.Variables)
+	v.variables = overrides["variables"].(map[string]interface{})
+	for _, input := range inputs {
+		if _, ok := v.variables[input.Name]; !ok {
+			v.variables[input.Name], _ = input.defaultValue()
+		}
+	}
+	for _, imported := range files[1:] {
+		for name, value := range decodeVariables(imported.root) {
+			if _, ok := v.variables[name]; !ok {
+				v.variables[name] = value
+			}
+		}
+	}
+	v.pluginDirs = pluginDirsOf(main)
+	strict, _ := decodeNode(lookup(main.root, "strict"))
+	v.lenient = opts.Lenient || strict == false
+
+	v.checkVariables(files)
+	// Problems with the templates of the variables are reported above.
+	_ = renderVariables(v.variables, true)
+
+	if env := lookup(main.root, "env"); env != nil {
+		v.checkTemplates(main.path, env, nil)
+	}
+	for _, file := range files {
+		if tasks := lookup(file.root, "tasks"); tasks != nil && tasks.Kind == yaml.SequenceNode {
+			for _, task := range tasks.Content {
+				v.checkTask(file, task)
+			}
+		}
+	}
+	v.checkGraph()
+
+	order := make(map[string]int, len(files))
+	for i, file := range files {
+		order[file.path] = i
+	}
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		a, b := v.problems[i], v.problems[j]
+		if a.File != b.File {
+			return order[a.File] < order[b.File]
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return v.problems
+}
+
+// validator collects the problems of a workflow and its imports.
+type validator struct {
+	problems []Problem
+	// variables are those of the main workflow, with the imported ones.
+	variables  map[string]interface{}
+	pluginDirs []string
+	// lenient is set for workflows opting out of strict templating, where
+	// undefined variables render as "<no value>".
+	lenient bool
+	// actionCheck and conditionCheck are the checks of the Options, if set.
+	actionCheck    func(action string, pluginDirs []string) error
+	conditionCheck func(condition string) error
+	tasks          []taskDefinition
+	// unexpanded match the names of the tasks that could not be expanded,
+	// so dependencies on them are not reported as unknown.
+	unexpanded []*regexp.Regexp
+}
+
+// sourceFile is a parsed workflow file.
+type sourceFile struct {
+	path string
+	root *yaml.Node
+	// namespace prefixes the names of the tasks of an imported file.
+	namespace string
+}
+
+// taskDefinition is a task as expanded by its foreach loops.
+type taskDefinition struct {
+	name      string
+	file      string
+	node      *yaml.Node
+	dependsOn []dependency
+	// references are the tasks it reads in .tasks templates.
+	references []dependency
+}
+
+// dependency is an entry of depends-on, or a task read in a template.
+type dependency struct {
+	name string
+	node *yaml.Node
+}
+
+// kind is the type expected for a value.
+type kind int
+
+const (
+	kindAny kind = iota
+	kindString
+	kindInt
+	kindBool
+	kindList
+	kindStrings
+	kindMap
+)
+
+// String describes a value of the kind.
+func (k kind) String() string {
+	switch k {
+	case kindString:
+		return "a string"
+	case kindInt:
+		return "an integer"
+	case kindBool:
+		return "true or false"
+	case kindList:
+		return "a list"
+	case kindStrings:
+		return "a list of strings"
+	case kindMap:
+		return "a map"
+	}
+	return "a value"
+}
+
+// field describes a key of a section of the workflow file.
+type field struct {
+	kind kind
+	// check verifies a string value, or each entry of a list of strings.
+	// Values holding templates are only complete once rendered, so they are
+	// not checked.
+	check func(string) error
+}
+
+var (
+	workflowFields = map[string]field{
+		"name":             {kind: kindString},
+		"description":      {kind: kindString},
+		"variables":        {kind: kindMap},
+		"tasks":            {kind: kindList},
+		"imports":          {kind: kindList},
+		"timeout":          {kind: kindString, check: checkTimeout},
+		"on-failure":       {kind: kindString, check: checkPolicy},
+		"plugins":          {kind: kindStrings},
+		"env":              {kind: kindMap},
+		"skip-propagation": {kind: kindString, check: checkSkipPropagation},
+		"strict":           {kind: kindBool},
+		"inputs":           {kind: kindMap},
+	}
+	inputFields = map[string]field{
+		"type":        {kind: kindString, check: checkInputType},
+		"description": {kind: kindString},
+		"default":     {kind: kindAny},
+		"required":    {kind: kindBool},
+		"values":      {kind: kindList},
+		"pattern":     {kind: kindString, check: checkPattern},
+	}
+	importFields = map[string]field{
+		"file": {kind: kindString},
+		"as":   {kind: kindString},
+	}
+	taskFields = map[string]field{
+		"name":             {kind: kindString},
+		"description":      {kind: kindString},
+		"do":               {kind: kindMap},
+		"cleanup":          {kind: kindMap},
+		"depends-on":       {kind: kindStrings},
+		"foreach":          {kind: kindList},
+		"timeout":          {kind: kindString, check: checkTimeout},
+		"retries":          {kind: kindInt},
+		"retry-delay":      {kind: kindString, check: checkTimeout},
+		"backoff":          {kind: kindString, check: checkBackoff},
+		"retry-jitter":     {kind: kindBool},
+		"retry-on":         {kind: kindList},
+		"on-failure":       {kind: kindString, check: checkPolicy},
+		"cleanup-when":     {kind: kindString, check: checkCleanupWhen},
+		"outputs":          {kind: kindList},
+		"when":             {kind: kindString},
+		"skip-propagation": {kind: kindString, check: checkSkipPropagation},
+		"trigger":          {kind: kindString, check: checkTrigger},
+		"inputs":           {kind: kindStrings, check: ValidateGlob},
+		"tags":             {kind: kindStrings},
+	}
+	foreachFields = map[string]field{
+		"variable": {kind: kindString},
+		"as":       {kind: kindString},
+	}
+	outputFields = map[string]field{
+		"path":    {kind: kindString},
+		"name":    {kind: kindString},
+		"from":    {kind: kindString},
+		"pattern": {kind: kindString},
+		"query":   {kind: kindString},
+		"key":     {kind: kindString},
+	}
+	actionFields = map[string]field{
+		"this": {kind: kindString},
+		"with": {kind: kindMap},
+	}
+	// commonParams are the parameters every action running a process takes.
+	commonParams = map[string]field{
+		"args":        {kind: kindList},
+		"env":         {kind: kindMap},
+		"env-file":    {kind: kindString},
+		"inherit-env": {kind: kindAny},
+		"dir":         {kind: kindString},
+		"flag-style":  {kind: kindString, check: checkFlagStyle},
+		"bool-flags":  {kind: kindString, check: checkBoolFlags},
+	}
+	// actionParams are the parameters of the built-in actions. Those of
+	// other actions are not checked.
+	actionParams = map[string]map[string]field{
+		"process": withFields(commonParams, map[string]field{
+			"this": {kind: kindString},
+			"path": {kind: kindString},
+		}),
+		"shell": withFields(commonParams, map[string]field{
+			"script": {kind: kindString},
+			"shell":  {kind: kindString},
+			"strict": {kind: kindBool},
+		}),
+		"workflow": {
+			"file":      {kind: kindString},
+			"variables": {kind: kindMap},
+			"threads":   {kind: kindInt},
+			"env":       {kind: kindMap},
+		},
+	}
+)
+
+// withFields merges sets of fields.
+func withFields(sets ...map[string]field) map[string]field {
+	merged := make(map[string]field)
+	for _, set := range sets {
+		for k, v := range set {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// yamlError matches the position yaml gives in its syntax errors.
+var yamlError = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// parse reads a workflow file. Problems reading an imported file are
+// reported at the entry of the importing file naming it.
+func (v *validator) parse(path string, importer string, from *yaml.Node) *sourceFile {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		v.reportFile(path, importer, from, "%v", err)
+		return nil
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".json":
+		// yaml accepts more than JSON, so the syntax is checked first.
+		var discard interface{}
+		if err := json.Unmarshal(data, &discard); err != nil {
+			var syntax *json.SyntaxError
+			if errors.As(err, &syntax) {
+				line, column := position(data, syntax.Offset)
+				v.problems = append(v.problems, Problem{File: path, Line: line, Column: column, Message: syntax.Error()})
+			} else {
+				v.report(path, nil, "%v", err)
+			}
+			return nil
+		}
+	case ".yaml", ".yml":
+	default:
+		v.reportFile(path, importer, from, "unsupported file format: %s (use .yaml, .yml, or .json)", ext)
+		return nil
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		problem := Problem{File: path, Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+		if m := yamlError.FindStringSubmatch(err.Error()); m != nil {
+			problem.Line, _ = strconv.Atoi(m[1])
+			problem.Message = m[2]
+		}
+		v.problems = append(v.problems, problem)
+		return nil
+	}
+	if len(document.Content) == 0 {
+		v.report(path, nil, "the workflow is empty")
+		return nil
+	}
+	root := resolve(document.Content[0])
+	if root.Kind != yaml.MappingNode {
+		v.report(path, root, "the workflow must be a map, got %s", describe(root))
+		return nil
+	}
+	return &sourceFile{path: path, root: root}
+}
+
+// position returns the line and column of a byte offset.
+func position(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := 1 + strings.Count(string(before), "\n")
+	column := int(offset) - strings.LastIndex(string(before), "\n")
+	return line, column
+}
+
+// report records a problem at a node, or at the start of the file if node
+// is nil.
+func (v *validator) report(file string, node *yaml.Node, format string, args ...interface{}) {
+	problem := Problem{File: file, Message: fmt.Sprintf(format, args...)}
+	if node != nil {
+		problem.Line, problem.Column = node.Line, node.Column
+	}
+	v.problems = append(v.problems, problem)
+}
+
+// reportFile records a problem with a whole file, at the import entry
+// naming it if it is imported.
+func (v *validator) reportFile(path string, importer string, from *yaml.Node, format string, args ...interface{}) {
+	if from == nil {
+		v.report(path, nil, format, args...)
+		return
+	}
+	v.report(importer, from, "import: %s", fmt.Sprintf(format, args...))
+}
+
+// checkFields reports the unknown keys of a map and the values of the wrong
+// type. Open sections accept keys they do not list.
+func (v *validator) checkFields(file string, node *yaml.Node, fields map[string]field, section string, open bool) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], resolve(node.Content[i+1])
+		if key.Value == "<<" {
+			continue
+		}
+		f, ok := fields[key.Value]
+		if !ok {
+			if !open {
+				v.report(file, key, "unknown key %q in %s", key.Value, section)
+			}
+			continue
+		}
+		if !hasKind(value, f.kind) {
+			v.report(file, value, "%s must be %s, got %s", key.Value, f.kind, describe(value))
+			continue
+		}
+		if f.check == nil {
+			continue
+		}
+		values := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			values = value.Content
+		}
+		for _, item := range values {
+			if item.Kind != yaml.ScalarNode || loaderTag(item) == "!!null" || strings.Contains(item.Value, "{{") {
+				continue
+			}
+			if err := f.check(item.Value); err != nil {
+				v.report(file, item, "%s: %v", key.Value, err)
+			}
+		}
+	}
+}
+
+// require reports the keys a map lacks.
+func (v *validator) require(file string, node *yaml.Node, section string, keys ...string) {
+	for _, key := range keys {
+		if value := lookup(node, key); value == nil || loaderTag(value) == "!!null" {
+			v.report(file, node, "%s has no %s", section, key)
+		}
+	}
+}
+
+// checkWorkflow checks the top-level keys of a workflow file and its imports.
+func (v *validator) checkWorkflow(file *sourceFile) {
+	v.checkFields(file.path, file.root, workflowFields, "workflow", false)
+	if imports := lookup(file.root, "imports"); imports != nil && imports.Kind == yaml.SequenceNode {
+		for _, entry := range imports.Content {
+			if v.checkEntry(file.path, entry, "import") {
+				v.checkFields(file.path, entry, importFields, "import", false)
+				v.require(file.path, entry, "import", "file", "as")
+			}
+		}
+	}
+	if env := lookup(file.root, "env"); env != nil && env.Kind == yaml.MappingNode {
+		v.checkScalars(file.path, env, "env")
+	}
+}
+
+// checkInputs checks the input declarations of the main workflow and the
+// values given for them in opts, and returns the inputs. Those that are not
+// valid are returned as plain strings, so templates reading them are not
+// reported as well.
+func (v *validator) checkInputs(file *sourceFile, opts Options) []Input {
+	node := lookup(file.root, "inputs")
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	variables := decodeVariables(file.root)
+	var inputs []Input
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], resolve(node.Content[i+1])
+		if _, ok := variables[key.Value]; ok {
+			v.report(file.path, key, "input %q is also a variable", key.Value)
+		}
+		if loaderTag(value) != "!!null" && !v.checkEntry(file.path, value, "input") {
+			inputs = append(inputs, Input{Name: key.Value, Type: InputString})
+			continue
+		}
+		before := len(v.problems)
+		v.checkFields(file.path, value, inputFields, "input", false)
+		var raw interface{}
+		if len(v.problems) > before || decodeInto(value, &raw) != nil {
+			inputs = append(inputs, Input{Name: key.Value, Type: InputString})
+			continue
+		}
+		parsed, err := parseInputs(map[string]interface{}{key.Value: ConvertKeysToString(raw)})
+		if err != nil {
+			v.report(file.path, value, "%v", err)
+			inputs = append(inputs, Input{Name: key.Value, Type: InputString})
+			continue
+		}
+		input := parsed[0]
+		if given, ok := opts.Variables[input.Name]; ok {
+			if _, err := input.Check(given); err != nil {
+				v.report(file.path, key, "input %s: %v", input.Name, err)
+			}
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs
+}
+
+// checkVariables checks the templates of the variable definitions, and
+// reports the variables reading each other.
+func (v *validator) checkVariables(files []*sourceFile) {
+	reads := make(map[string][]string)
+	for _, file := range files {
+		variables := lookup(file.root, "variables")
+		if variables == nil || variables.Kind != yaml.MappingNode {
+			continue
+		}
+		for i := 0; i+1 < len(variables.Content); i += 2 {
+			name, value := variables.Content[i].Value, variables.Content[i+1]
+			v.checkTemplates(file.path, value, nil)
+			var raw interface{}
+			if decodeInto(value, &raw) != nil {
+				continue
+			}
+			for _, field := range templateVariables(ConvertKeysToString(raw)) {
+				if _, ok := v.variables[field]; !ok {
+					continue
+				}
+				if field == name {
+					v.report(file.path, value, "variable %q reads itself", name)
+				} else if !addDependency(reads, name, field) {
+					v.report(file.path, value, "variable %q reads %q, which already reads it", name, field)
+				}
+			}
+		}
+	}
+}
+
+// checkEntry reports a list entry that is not a map.
+func (v *validator) checkEntry(file string, node *yaml.Node, section string) bool {
+	node = resolve(node)
+	if node.Kind != yaml.MappingNode {
+		v.report(file, node, "%s must be a map, got %s", section, describe(node))
+		return false
+	}
+	return true
+}
+
+// checkScalars reports the values of a map that are not plain values.
+func (v *validator) checkScalars(file string, node *yaml.Node, key string) {
+	for i := 1; i < len(node.Content); i += 2 {
+		if value := resolve(node.Content[i]); value.Kind != yaml.ScalarNode {
+			v.report(file, value, "%s %s must be a plain value, got %s", key, node.Content[i-1].Value, describe(value))
+		}
+	}
+}
+
+// checkTask checks a task and records the tasks it expands into.
+func (v *validator) checkTask(file *sourceFile, node *yaml.Node) {
+	node = resolve(node)
+	if !v.checkEntry(file.path, node, "task") {
+		return
+	}
+	before := len(v.problems)
+	v.checkFields(file.path, node, taskFields, "task", false)
+	v.require(file.path, node, "task", "name", "do")
+	for _, key := range []string{"do", "cleanup"} {
+		if action := lookup(node, key); action != nil && action.Kind == yaml.MappingNode {
+			v.checkAction(file.path, action, key)
+		}
+	}
+	// Conditions still holding templates are only complete at launch.
+	if when := lookup(node, "when"); v.conditionCheck != nil && when != nil && when.Kind == yaml.ScalarNode && !strings.Contains(when.Value, "{{") {
+		if err := v.conditionCheck(when.Value); err != nil {
+			v.report(file.path, when, "when: %v", err)
+		}
+	}
+	if outputs := lookup(node, "outputs"); outputs != nil && outputs.Kind == yaml.SequenceNode {
+		for _, output := range outputs.Content {
+			if output = resolve(output); output.Kind == yaml.MappingNode {
+				v.checkFields(file.path, output, outputFields, "output", false)
+			} else if output.Kind != yaml.ScalarNode {
+				v.report(file.path, output, "outputs must be file paths or maps, got %s", describe(output))
+			}
+		}
+	}
+
+	// The foreach loops bind the names their templates may use.
+	scope := map[string]struct{}{}
+	expandable := true
+	if foreach := lookup(node, "foreach"); foreach != nil && foreach.Kind == yaml.SequenceNode {
+		for _, loop := range foreach.Content {
+			loop = resolve(loop)
+			if !v.checkEntry(file.path, loop, "foreach") {
+				expandable = false
+				continue
+			}
+			v.checkFields(file.path, loop, foreachFields, "foreach", false)
+			v.require(file.path, loop, "foreach", "variable", "as")
+			if as := scalar(lookup(loop, "as")); as != "" {
+				scope[as] = struct{}{}
+			}
+			variable := lookup(loop, "variable")
+			name := scalar(variable)
+			if name == "" {
+				expandable = false
+				continue
+			}
+			value, ok := v.variables[name]
+			if !ok {
+				v.report(file.path, variable, "foreach variable %q is not defined", name)
+				expandable = false
+			} else if _, ok := value.([]interface{}); !ok {
+				v.report(file.path, variable, "foreach variable %q is not a list", name)
+				expandable = false
+			}
+		}
+	} else if foreach != nil && loaderTag(foreach) != "!!null" {
+		expandable = false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if key := node.Content[i].Value; key != "foreach" {
+			v.checkTemplates(file.path, node.Content[i+1], scope)
+		}
+	}
+	if !expandable {
+		v.skipNames(file, node)
+		return
+	}
+
+	var raw interface{}
+	if err := decodeInto(node, &raw); err != nil {
+		v.report(file.path, node, "%v", err)
+		v.skipNames(file, node)
+		return
+	}
+	taskMap, _ := ConvertKeysToString(raw).(map[string]interface{})
+	bindings := []map[string]interface{}{v.variables}
+	if _, ok := taskMap["foreach"]; ok {
+		var err error
+		if bindings, err = foreachBindings(taskMap["foreach"], v.variables); err != nil {
+			v.report(file.path, lookup(node, "foreach"), "%v", err)
+			v.skipNames(file, node)
+			return
+		}
+	}
+	v.expandNames(file, node, bindings)
+
+	// The remaining settings are checked on the loaded tasks, unless
+	// something is already wrong with this one.
+	if len(v.problems) > before {
+		return
+	}
+	delete(taskMap, "foreach")
+	reported := map[string]struct{}{}
+	for _, binding := range bindings {
+		var task Task
+		encoded, err := json.Marshal(ReplacePlaceholders(taskMap, binding))
+		if err == nil {
+			err = json.Unmarshal(encoded, &task)
+		}
+		if err == nil {
+			err = task.validate()
+		}
+		if err != nil {
+			if _, ok := reported[err.Error()]; !ok {
+				reported[err.Error()] = struct{}{}
+				v.report(file.path, node, "task %s: %v", task.Name, err)
+			}
+		}
+	}
+}
+
+// checkAction checks the keys of a do or cleanup action and, for the
+// built-in actions, its parameters.
+func (v *validator) checkAction(file string, node *yaml.Node, key string) {
+	v.checkFields(file, node, actionFields, key, false)
+	action := scalar(lookup(node, "this"))
+	if action == "" {
+		action = "process"
+	}
+	if _, ok := actionParams[action]; !ok && v.actionCheck != nil && !strings.Contains(action, "{{") {
+		if err := v.actionCheck(action, v.pluginDirs); err != nil {
+			v.report(file, lookup(node, "this"), "%v", err)
+		}
+	}
+	with := lookup(node, "with")
+	if with == nil || with.Kind != yaml.MappingNode {
+		return
+	}
+	if params, ok := actionParams[action]; ok {
+		v.checkFields(file, with, params, action+" action", false)
+	} else {
+		// Other actions take parameters of their own.
+		v.checkFields(file, with, commonParams, action+" action", true)
+	}
+	if env := lookup(with, "env"); env != nil && env.Kind == yaml.MappingNode {
+		v.checkScalars(file, env, "env")
+	}
+}
+
+// expandNames records the tasks a task expands into.
+func (v *validator) expandNames(file *sourceFile, node *yaml.Node, bindings []map[string]interface{}) {
+	nameNode := lookup(node, "name")
+	name := scalar(nameNode)
+	if name == "" {
+		return
+	}
+	var dependsOn []*yaml.Node
+	if deps := lookup(node, "depends-on"); deps != nil && deps.Kind == yaml.SequenceNode {
+		dependsOn = deps.Content
+	}
+	var references []dependency
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if key := node.Content[i].Value; key != "name" && key != "foreach" {
+			references = append(references, taskReferences(node.Content[i+1])...)
+		}
+	}
+	for _, binding := range bindings {
+		task := taskDefinition{name: renderName(name, binding), file: file.path, node: nameNode, references: references}
+		if file.namespace != "" {
+			task.name = file.namespace + "." + task.name
+		}
+		for _, dep := range dependsOn {
+			depName := renderName(scalar(dep), binding)
+			if file.namespace != "" && !strings.Contains(depName, ".") {
+				depName = file.namespace + "." + depName
+			}
+			task.dependsOn = append(task.dependsOn, dependency{name: depName, node: dep})
+		}
+		v.tasks = append(v.tasks, task)
+	}
+}
+
+// taskReferences returns the tasks read in the .tasks templates of a value.
+func taskReferences(node *yaml.Node) []dependency {
+	node = resolve(node)
+	var references []dependency
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			references = append(references, taskReferences(node.Content[i])...)
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			references = append(references, taskReferences(item)...)
+		}
+	case yaml.ScalarNode:
+		if !taskReference.MatchString(node.Value) {
+			return nil
+		}
+		if tmpl, err := newTemplate("task").Parse(node.Value); err == nil {
+			for _, name := range referencedTasks(tmpl.Root) {
+				references = append(references, dependency{name: name, node: node})
+			}
+		}
+	}
+	return references
+}
+
+// skipNames records the name of a task that cannot be expanded as a pattern,
+// each template in it matching anything.
+func (v *validator) skipNames(file *sourceFile, node *yaml.Node) {
+	name := scalar(lookup(node, "name"))
+	if name == "" {
+		return
+	}
+	if file.namespace != "" {
+		name = file.namespace + "." + name
+	}
+	parts := templateAction.Split(name, -1)
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	v.unexpanded = append(v.unexpanded, regexp.MustCompile("^"+strings.Join(parts, ".*")+"$"))
+}
+
+// templateAction matches the actions of a template.
+var templateAction = regexp.MustCompile(`\{\{.*?\}\}`)
+
+// renderName renders a task name, leaving it as is if it cannot be rendered.
+func renderName(name string, variables map[string]interface{}) string {
+	tmpl, err := newTemplate("name").Parse(name)
+	if err != nil {
+		return name
+	}
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, variables); err != nil {
+		return name
+	}
+	return rendered.String()
+}
+
+// checkGraph reports duplicate task names, dependencies on unknown tasks,
+// dependency cycles, and .tasks templates reading unknown tasks or tasks
+// that are not dependencies.
+func (v *validator) checkGraph() {
+	defined := make(map[string]taskDefinition, len(v.tasks))
+	for _, task := range v.tasks {
+		if first, ok := defined[task.name]; ok {
+			where := fmt.Sprintf("line %d", first.node.Line)
+			if first.file != task.file {
+				where = fmt.Sprintf("%s:%d", first.file, first.node.Line)
+			}
+			v.report(task.file, task.node, "duplicate task name %q, first defined at %s", task.name, where)
+			continue
+		}
+		defined[task.name] = task
+	}
+
+	graph := make(map[string][]string, len(v.tasks))
+	for _, task := range v.tasks {
+		for _, dep := range task.dependsOn {
+			if _, ok := defined[dep.name]; !ok {
+				if !v.isUnexpanded(dep.name) {
+					v.report(task.file, dep.node, "task %q depends on unknown task %q", task.name, dep.name)
+				}
+				continue
+			}
+			if dep.name == task.name {
+				v.report(task.file, dep.node, "task %q depends on itself", task.name)
+				continue
+			}
+			if !addDependency(graph, task.name, dep.name) {
+				v.report(task.file, dep.node, "task %q depends on %q, which already depends on it", task.name, dep.name)
+			}
+		}
+	}
+
+	dependsOn := make(map[string][]string, len(v.tasks))
+	for _, task := range v.tasks {
+		for _, dep := range task.dependsOn {
+			dependsOn[task.name] = append(dependsOn[task.name], dep.name)
+		}
+	}
+	for _, task := range v.tasks {
+		for _, ref := range task.references {
+			if _, ok := defined[ref.name]; !ok {
+				if !v.isUnexpanded(ref.name) {
+					v.report(task.file, ref.node, "task %q reads unknown task %q in .tasks", task.name, ref.name)
+				}
+			} else if !dependsOnTask(dependsOn, task.name, ref.name) {
+				v.report(task.file, ref.node, "task %q reads task %q in .tasks without depending on it", task.name, ref.name)
+			}
+		}
+	}
+}
+
+// isUnexpanded reports whether a name may be that of a task that could not
+// be expanded.
+func (v *validator) isUnexpanded(name string) bool {
+	for _, pattern := range v.unexpanded {
+		if pattern.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTemplates reports the templates of a value that do not parse or read
+// variables that are neither defined nor in scope. Templates reading .tasks
+// are only rendered at launch, so that name is always in scope.
+func (v *validator) checkTemplates(file string, node *yaml.Node, scope map[string]struct{}) {
+	node = resolve(node)
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			v.checkTemplates(file, node.Content[i], scope)
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			v.checkTemplates(file, item, scope)
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "{{") {
+			return
+		}
+		tmpl, err := newTemplate("template").Parse(node.Value)
+		if err != nil {
+			v.report(file, node, "invalid template: %s", templateError.ReplaceAllString(err.Error(), ""))
+			return
+		}
+		if v.lenient {
+			return
+		}
+		reported := map[string]struct{}{}
+		for _, name := range templateFields(tmpl.Tree.Root) {
+			if _, ok := reported[name]; ok || name == "tasks" {
+				continue
+			}
+			if _, ok := v.variables[name]; ok {
+				continue
+			}
+			if _, ok := scope[name]; ok {
+				continue
+			}
+			reported[name] = struct{}{}
+			v.report(file, node, "undefined variable %q", name)
+		}
+	}
+}
+
+// templateError matches the position text/template puts before its errors.
+var templateError = regexp.MustCompile(`^template: \w+:\d+:(\d+:)? `)
+
+// templateFields returns the names of the variables a template reads from
+// its data, such as "version" for {{.version}} or {{$.version}}. Fields read
+// inside range and with blocks are relative to another value and ignored.
+func templateFields(node parse.Node) []string {
+	var names []string
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			names = append(names, templateFields(child)...)
+		}
+	case *parse.ActionNode:
+		names = templateFields(n.Pipe)
+	case *parse.IfNode:
+		names = append(templateFields(n.Pipe), templateFields(n.List)...)
+		names = append(names, templateFields(n.ElseList)...)
+	case *parse.RangeNode:
+		names = append(templateFields(n.Pipe), templateFields(n.ElseList)...)
+	case *parse.WithNode:
+		names = append(templateFields(n.Pipe), templateFields(n.ElseList)...)
+	case *parse.TemplateNode:
+		names = templateFields(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			for _, arg := range cmd.Args {
+				names = append(names, templateFields(arg)...)
+			}
+		}
+	case *parse.ChainNode:
+		names = templateFields(n.Node)
+	case *parse.FieldNode:
+		names = n.Ident[:1]
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			names = n.Ident[1:2]
+		}
+	}
+	return names
+}
+
+// hasKind reports whether a value is of the given kind. Null stands for an
+// unset value and is always accepted.
+func hasKind(node *yaml.Node, k kind) bool {
+	if loaderTag(node) == "!!null" {
+		return true
+	}
+	switch k {
+	case kindString:
+		return node.Kind == yaml.ScalarNode && loaderTag(node) == "!!str"
+	case kindInt:
+		return node.Kind == yaml.ScalarNode && loaderTag(node) == "!!int"
+	case kindBool:
+		return node.Kind == yaml.ScalarNode && loaderTag(node) == "!!bool"
+	case kindList:
+		return node.Kind == yaml.SequenceNode
+	case kindStrings:
+		if node.Kind != yaml.SequenceNode {
+			return false
+		}
+		for _, item := range node.Content {
+			if item = resolve(item); item.Kind != yaml.ScalarNode || loaderTag(item) != "!!str" {
+				return false
+			}
+		}
+		return true
+	case kindMap:
+		return node.Kind == yaml.MappingNode
+	}
+	return true
+}
+
+// describe names the type of a value for problem messages.
+func describe(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		return "a list"
+	case yaml.MappingNode:
+		return "a map"
+	}
+	switch loaderTag(node) {
+	case "!!int", "!!float":
+		return "the number " + node.Value
+	case "!!bool":
+		return node.Value
+	case "!!null":
+		return "nothing"
+	}
+	return strconv.Quote(node.Value)
+}
+
+// decodeNode decodes a value the way the loader reads workflow files, with
+// yaml.v2, where plain yes, no, on and off are booleans. The validator parses
+// files with yaml.v3 for the positions, which reads them as strings.
+func decodeNode(node *yaml.Node) (interface{}, error) {
+	node = resolve(node)
+	if node == nil {
+		return nil, nil
+	}
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	if err := yamlv2.Unmarshal(data, &raw); err != nil {
+		// Aliases to anchors outside of the node only resolve in the file.
+		if node.Decode(&raw) != nil {
+			return nil, err
+		}
+	}
+	return ConvertKeysToString(raw), nil
+}
+
+// decodeInto is decodeNode storing the value in raw.
+func decodeInto(node *yaml.Node, raw *interface{}) error {
+	value, err := decodeNode(node)
+	if err == nil {
+		*raw = value
+	}
+	return err
+}
+
+// loaderTag returns the tag of a value as the loader reads it: !!bool for a
+// plain yes, where yaml.v3 gives !!str.
+func loaderTag(node *yaml.Node) string {
+	node = resolve(node)
+	if node.Kind != yaml.ScalarNode {
+		return node.ShortTag()
+	}
+	value, err := decodeNode(node)
+	if err != nil {
+		return node.ShortTag()
+	}
+	switch value.(type) {
+	case nil:
+		return "!!null"
+	case bool:
+		return "!!bool"
+	case int, int64, uint64:
+		return "!!int"
+	case float64:
+		return "!!float"
+	case string:
+		return "!!str"
+	}
+	return node.ShortTag()
+}
+
+// resolve follows aliases to the node they stand for.
+func resolve(node *yaml.Node) *yaml.Node {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+// lookup returns the value of a key of a map node, or nil.
+func lookup(node *yaml.Node, key string) *yaml.Node {
+	node = resolve(node)
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return resolve(node.Content[i+1])
+		}
+	}
+	return nil
+}
+
+// scalar returns the value of a string node, or "" for other nodes.
+func scalar(node *yaml.Node) string {
+	if node == nil || node.Kind != yaml.ScalarNode || loaderTag(node) != "!!str" {
+		return ""
+	}
+	return node.Value
+}
+
+// decodeVariables returns the variables of a workflow file.
+func decodeVariables(root *yaml.Node) map[string]interface{} {
+	var raw interface{}
+	if node := lookup(root, "variables"); node == nil || decodeInto(node, &raw) != nil {
+		return map[string]interface{}{}
+	}
+	variables, ok := ConvertKeysToString(raw).(map[string]interface{})
+	if !ok {
+		return map[string]interface{}{}
+	}
+	return variables
+}
+
+// pluginDirsOf returns the plugin directories of a workflow file, relative to
+// that file.
+func pluginDirsOf(file *sourceFile) []string {
+	var dirs []string
+	if plugins := lookup(file.root, "plugins"); plugins != nil && plugins.Kind == yaml.SequenceNode {
+		for _, dir := range plugins.Content {
+			if s := scalar(dir); s != "" {
+				if !filepath.IsAbs(s) {
+					s = filepath.Join(filepath.Dir(file.path), s)
+				}
+				dirs = append(dirs, s)
+			}
+		}
+	}
+	return dirs
+}
+
+func checkTimeout(value string) error {
+	_, err := ParseTimeout(value)
+	return err
+}
+
+func checkPolicy(value string) error {
+	if !IsValidCancelPolicy(value) {
+		return fmt.Errorf("unknown value %q (use abort-related-flows, abort-all or continue)", value)
+	}
+	return nil
+}
+
+func checkSkipPropagation(value string) error {
+	if !IsValidSkipPropagation(value) {
+		return fmt.Errorf("unknown value %q (use any, all or none)", value)
+	}
+	return nil
+}
+
+func checkBackoff(value string) error {
+	switch value {
+	case "", "fixed", "exponential":
+		return nil
+	}
+	return fmt.Errorf("unknown value %q (use fixed or exponential)", value)
+}
+
+func checkCleanupWhen(value string) error {
+	switch value {
+	case "", CleanupAlways, CleanupOnSuccess, CleanupOnFailure, CleanupOnCancel:
+		return nil
+	}
+	return fmt.Errorf("unknown value %q (use always, on-success, on-failure or on-cancel)", value)
+}
+
+func checkTrigger(value string) error {
+	if !IsValidTrigger(value) {
+		return fmt.Errorf("unknown value %q (use all-success, all-done, one-failed or always)", value)
+	}
+	return nil
+}
+
+func checkInputType(value string) error {
+	switch value {
+	case InputString, InputInt, InputBool, InputList, InputEnum:
+		return nil
+	}
+	return fmt.Errorf("unknown value %q (use string, int, bool, list or enum)", value)
+}
+
+func checkPattern(value string) error {
+	_, err := regexp.Compile(value)
+	return err
+}
+
+func checkFlagStyle(value string) error {
+	if !IsValidFlagStyle(value) {
+		return fmt.Errorf("unknown value %q (use equals, space or short)", value)
+	}
+	return nil
+}
+
+func checkBoolFlags(value string) error {
+	if !IsValidBoolFlags(value) {
+		return fmt.Errorf("unknown value %q (use value or bare)", value)
+	}
+	return nil
+}
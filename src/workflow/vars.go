@@ -0,0 +1,176 @@
+package workflow
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// VarEnvPrefix starts the names of the environment variables overriding
+// workflow variables: GOTASKER_VAR_version sets the variable version.
+const VarEnvPrefix = "GOTASKER_VAR_"
+
+// Sources of the variables of a workflow, as reported by the dry run.
+const (
+	// SourceFile marks the variables defined in the workflow file.
+	SourceFile = "workflow file"
+	// SourceOverride marks the variables given in Options without a
+	// source of their own.
+	SourceOverride = "override"
+	// SourceFlag marks the variables given with -var.
+	SourceFlag = "-var"
+	// SourceInputDefault marks the inputs left to their default.
+	SourceInputDefault = "input default"
+)
+
+// ParseValue reads a value given on the command line or in the environment
+// as YAML, with the rules of the workflow file, so numbers, booleans
+// (including yes, no, on and off) and lists such as [a, b] keep their type.
+// Anything else, including invalid YAML, is a string, and so are numbers
+// that would not print back the same, such as 1.10 or 007.
+func ParseValue(s string) interface{} {
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(s), &document); err != nil || len(document.Content) == 0 {
+		return s
+	}
+	switch root := resolve(document.Content[0]); root.Kind {
+	case yaml.SequenceNode, yaml.ScalarNode:
+		return typedValue(root)
+	}
+	return s
+}
+
+// typedValue returns the value of a scalar, or the values of a list or a
+// map, as ParseValue does.
+func typedValue(node *yaml.Node) interface{} {
+	node = resolve(node)
+	switch node.Kind {
+	case yaml.SequenceNode:
+		items := make([]interface{}, len(node.Content))
+		for i, item := range node.Content {
+			items[i] = typedValue(item)
+		}
+		return items
+	case yaml.MappingNode:
+		values := make(map[string]interface{}, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			values[resolve(node.Content[i]).Value] = typedValue(node.Content[i+1])
+		}
+		return values
+	}
+	value, err := decodeNode(node)
+	if err != nil {
+		return node.Value
+	}
+	switch value.(type) {
+	case bool:
+		return value
+	case int, float64:
+		if fmt.Sprint(value) == node.Value {
+			return value
+		}
+	}
+	return node.Value
+}
+
+// loadVarFile reads the variables of a var file. YAML values are read as
+// ParseValue reads them, so a file and a -var give the same value.
+func loadVarFile(path string) (map[string]interface{}, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+	default:
+		return loadWorkflowFile(path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("error parsing YAML: %w", err)
+	}
+	if len(document.Content) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	root := resolve(document.Content[0])
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("a var file must be a map of variables")
+	}
+	return typedValue(root).(map[string]interface{}), nil
+}
+
+// ParseVar parses a NAME=VALUE assignment, the value as with ParseValue.
+func ParseVar(assignment string) (string, interface{}, error) {
+	name, value, ok := strings.Cut(assignment, "=")
+	if !ok || name == "" {
+		return "", nil, fmt.Errorf("invalid variable %q, expected NAME=VALUE", assignment)
+	}
+	return name, ParseValue(value), nil
+}
+
+// ResolveOverrides returns the options overriding the variables of a
+// workflow with, in increasing precedence, the GOTASKER_VAR_ variables of
+// environ, the variables of each var file in turn (a YAML or JSON map), and
+// each NAME=VALUE assignment in turn.
+func ResolveOverrides(environ []string, varFiles []string, assignments []string) (Options, error) {
+	opts := Options{
+		Variables: make(map[string]interface{}),
+		Sources:   make(map[string]string),
+	}
+	var fromEnv []string
+	for _, entry := range environ {
+		if name, value, ok := strings.Cut(entry, "="); ok && strings.HasPrefix(name, VarEnvPrefix) && len(name) > len(VarEnvPrefix) {
+			opts.Variables[name[len(VarEnvPrefix):]] = ParseValue(value)
+			fromEnv = append(fromEnv, name)
+		}
+	}
+	sort.Strings(fromEnv)
+	for _, name := range fromEnv {
+		opts.Sources[name[len(VarEnvPrefix):]] = "env " + name
+	}
+	for _, file := range varFiles {
+		variables, err := loadVarFile(file)
+		if err != nil {
+			return Options{}, fmt.Errorf("var file %s: %w", file, err)
+		}
+		for name, value := range variables {
+			opts.Variables[name] = value
+			opts.Sources[name] = "var file " + file
+		}
+	}
+	for _, assignment := range assignments {
+		name, value, err := ParseVar(assignment)
+		if err != nil {
+			return Options{}, err
+		}
+		opts.Variables[name] = value
+		opts.Sources[name] = SourceFlag
+	}
+	return opts, nil
+}
+
+// variableSources names where each variable of a workflow comes from: the
+// overrides, the workflow file, or the file importing them.
+func variableSources(variables map[string]interface{}, defined map[string]struct{}, imported map[string]string, opts Options) map[string]string {
+	sources := make(map[string]string, len(variables))
+	for name := range variables {
+		_, overridden := opts.Variables[name]
+		_, isDefined := defined[name]
+		switch {
+		case overridden:
+			sources[name] = opts.Sources[name]
+			if sources[name] == "" {
+				sources[name] = SourceOverride
+			}
+		case isDefined:
+			sources[name] = SourceFile
+		default:
+			sources[name] = imported[name]
+		}
+	}
+	return sources
+}
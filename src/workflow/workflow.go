@@ -4,22 +4,227 @@ package workflow
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
-	"text/template"
+	"text/template/parse"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
 // Task represents a task in the workflow with its dependencies and actions.
 type Task struct {
-	Name      string    `json:"name"`
-	Do        Action    `json:"do"`
-	Cleanup   Action    `json:"cleanup"`
-	DependsOn []string  `json:"depends-on"`
-	ForEach   []ForEach `json:"foreach"`
+	Name        string        `json:"name"`
+	Do          Action        `json:"do"`
+	Cleanup     Action        `json:"cleanup"`
+	DependsOn   []string      `json:"depends-on"`
+	ForEach     []ForEach     `json:"foreach"`
+	Timeout     string        `json:"timeout"`
+	Retries     int           `json:"retries"`
+	RetryDelay  string        `json:"retry-delay"`
+	Backoff     string        `json:"backoff"`
+	RetryJitter bool          `json:"retry-jitter"`
+	RetryOn     []interface{} `json:"retry-on"`
+	OnFailure   string        `json:"on-failure"`
+	CleanupWhen string        `json:"cleanup-when"`
+	Outputs     []Output      `json:"outputs"`
+	// When is a condition, in the language of the expr package, deciding
+	// right before launch whether the task runs or is skipped.
+	When string `json:"when"`
+	// SkipPropagation decides when a task is skipped because of its skipped
+	// dependencies, overriding the workflow setting.
+	SkipPropagation string `json:"skip-propagation"`
+	// Trigger is the rule deciding, from the outcome of its dependencies,
+	// whether the task runs: all-success (the default), all-done,
+	// one-failed or always.
+	Trigger string `json:"trigger"`
+	// Inputs are globs of the files the task reads. With Outputs naming
+	// files, they let unchanged tasks be skipped as up to date.
+	Inputs []string `json:"inputs"`
+	// Tags label the task so runs can include or exclude it with --tags and
+	// --skip-tags.
+	Tags []string `json:"tags"`
+	// ForEachItems are the items of the foreach loops the task was expanded
+	// from, under their "as" names, for the templates rendered at launch.
+	ForEachItems map[string]interface{} `json:"foreach-items,omitempty"`
+}
+
+// HasTag reports whether the task has one of the given tags.
+func (t *Task) HasTag(tags []string) bool {
+	for _, tag := range t.Tags {
+		for _, wanted := range tags {
+			if tag == wanted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// OutputPaths returns the files the task declares as outputs.
+func (t *Task) OutputPaths() []string {
+	var paths []string
+	for _, output := range t.Outputs {
+		if output.Path != "" {
+			paths = append(paths, output.Path)
+		}
+	}
+	return paths
+}
+
+// IsIncremental reports whether the task declares inputs or output files, so
+// it can be skipped when none of them changed since its last run.
+func (t *Task) IsIncremental() bool {
+	return len(t.Inputs) > 0 || len(t.OutputPaths()) > 0
+}
+
+// Values accepted by the cleanup-when setting of a task.
+const (
+	CleanupAlways    = "always"
+	CleanupOnSuccess = "on-success"
+	CleanupOnFailure = "on-failure"
+	CleanupOnCancel  = "on-cancel"
+)
+
+// Values accepted by the skip-propagation setting.
+const (
+	// SkipPropagationAny skips a task when any of its dependencies was
+	// skipped. It is the default.
+	SkipPropagationAny = "any"
+	// SkipPropagationAll skips a task only when all of its dependencies
+	// were skipped.
+	SkipPropagationAll = "all"
+	// SkipPropagationNone never skips a task because of its dependencies.
+	SkipPropagationNone = "none"
+)
+
+// IsValidSkipPropagation reports whether the given name is a known
+// skip-propagation rule. The empty string stands for the default.
+func IsValidSkipPropagation(rule string) bool {
+	switch rule {
+	case "", SkipPropagationAny, SkipPropagationAll, SkipPropagationNone:
+		return true
+	}
+	return false
+}
+
+// Cancel policies, applied by the DAG when a task fails.
+const (
+	// PolicyAbortRelatedFlows cancels the pending tasks of every flow that
+	// contains the failed task. It is the default policy.
+	PolicyAbortRelatedFlows = "abort-related-flows"
+	// PolicyAbortAll cancels every pending task.
+	PolicyAbortAll = "abort-all"
+	// PolicyContinue cancels nothing.
+	PolicyContinue = "continue"
+)
+
+// IsValidCancelPolicy reports whether the given name is a known cancel policy.
+func IsValidCancelPolicy(policy string) bool {
+	switch policy {
+	case PolicyAbortRelatedFlows, PolicyAbortAll, PolicyContinue:
+		return true
+	}
+	return false
+}
+
+// Trigger rules deciding when a task runs according to the statuses of its
+// dependencies (its parents).
+const (
+	// TriggerAllSuccess runs the task once all parents have finished, only
+	// if they all succeeded (or were skipped or up to date). It is the default: without an
+	// explicit trigger the cancel policies enforce it, and the continue
+	// policy lets dependents of a failed task run.
+	TriggerAllSuccess = "all-success"
+	// TriggerAllDone runs the task once all parents have finished, whatever
+	// their outcome.
+	TriggerAllDone = "all-done"
+	// TriggerOneFailed runs the task as soon as one parent has failed or
+	// timed out, and skips it if they all finish without failing.
+	TriggerOneFailed = "one-failed"
+	// TriggerAlways runs the task without waiting for its parents.
+	TriggerAlways = "always"
+)
+
+// IsValidTrigger reports whether the given name is a known trigger rule. The
+// empty string stands for the default.
+func IsValidTrigger(rule string) bool {
+	switch rule {
+	case "", TriggerAllSuccess, TriggerAllDone, TriggerOneFailed, TriggerAlways:
+		return true
+	}
+	return false
+}
+
+// Flag styles selecting how map entries of the args param are rendered.
+const (
+	// FlagStyleEquals renders --key=value. It is the default.
+	FlagStyleEquals = "equals"
+	// FlagStyleSpace renders --key value.
+	FlagStyleSpace = "space"
+	// FlagStyleShort renders -key value.
+	FlagStyleShort = "short"
+)
+
+// Renderings of the boolean map entries of the args param.
+const (
+	// BoolFlagsValue renders booleans as values, like --key=true and
+	// --key=false. It is the default.
+	BoolFlagsValue = "value"
+	// BoolFlagsBare renders true as a bare --key and omits false.
+	BoolFlagsBare = "bare"
+)
+
+// IsValidFlagStyle reports whether the given name is a known flag style.
+func IsValidFlagStyle(style string) bool {
+	switch style {
+	case "", FlagStyleEquals, FlagStyleSpace, FlagStyleShort:
+		return true
+	}
+	return false
+}
+
+// IsValidBoolFlags reports whether the given name is a known rendering of
+// boolean flags.
+func IsValidBoolFlags(rendering string) bool {
+	switch rendering {
+	case "", BoolFlagsValue, BoolFlagsBare:
+		return true
+	}
+	return false
+}
+
+// ParseInheritEnv parses an inherit-env setting. It returns nil when the
+// whole environment is inherited (the setting is unset or true), an empty set
+// when nothing is (false), and the allowed names for an allowlist.
+func ParseInheritEnv(value interface{}) (map[string]bool, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		if v {
+			return nil, nil
+		}
+		return map[string]bool{}, nil
+	case []interface{}:
+		allowed := make(map[string]bool, len(v))
+		for _, name := range v {
+			s, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("inherit-env entries must be variable names, got %v", name)
+			}
+			allowed[s] = true
+		}
+		return allowed, nil
+	}
+	return nil, fmt.Errorf("inherit-env must be true, false or a list of variable names, got %v", value)
 }
 
 // Action represents an action to be performed with its parameters.
@@ -28,11 +233,91 @@ type Action struct {
 	With With   `json:"with"`
 }
 
+// IsSet reports whether the action is defined at all.
+func (a Action) IsSet() bool {
+	return a.This != "" || a.With.Path != "" || len(a.With.Params) > 0
+}
+
 // With represents the parameters for an action.
 type With struct {
-	This string        `json:"this"`
-	Args []interface{} `json:"args"`
-	Path string        `json:"path"`
+	This string        `json:"this,omitempty"`
+	Args []interface{} `json:"args,omitempty"`
+	Path string        `json:"path,omitempty"`
+	// Env sets environment variables of the action, over the workflow env.
+	Env map[string]interface{} `json:"env,omitempty"`
+	// EnvFile is a file of KEY=VALUE lines loaded before Env, relative to
+	// Dir when that is set.
+	EnvFile string `json:"env-file,omitempty"`
+	// InheritEnv is true (the default) to pass the environment of gotasker
+	// on, false to start from an empty one, or a list of the variable names
+	// to pass on.
+	InheritEnv interface{} `json:"inherit-env,omitempty"`
+	// Dir is the working directory of the action.
+	Dir string `json:"dir,omitempty"`
+	// FlagStyle sets how map entries of Args render: equals (--key=value,
+	// the default), space (--key value) or short (-key value).
+	FlagStyle string `json:"flag-style,omitempty"`
+	// BoolFlags sets how boolean map entries of Args render: value
+	// (--key=true, the default) or bare (--key for true, nothing for false).
+	BoolFlags string `json:"bool-flags,omitempty"`
+	// Params holds the parameters that have no field of their own, such as
+	// the script of the shell action.
+	Params map[string]interface{} `json:"-"`
+}
+
+// knownParams maps the parameters With has a field for, by their JSON name,
+// to the index of the field.
+var knownParams = func() map[string]int {
+	known := make(map[string]int)
+	t := reflect.TypeOf(With{})
+	for i := 0; i < t.NumField(); i++ {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); name != "" && name != "-" {
+			known[name] = i
+		}
+	}
+	return known
+}()
+
+// UnmarshalJSON decodes the known parameters into their fields and keeps the
+// others in Params.
+func (w *With) UnmarshalJSON(data []byte) error {
+	type plain With
+	if err := json.Unmarshal(data, (*plain)(w)); err != nil {
+		return err
+	}
+	var params map[string]interface{}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return err
+	}
+	for name := range knownParams {
+		delete(params, name)
+	}
+	w.Params = nil
+	if len(params) > 0 {
+		w.Params = params
+	}
+	return nil
+}
+
+// MarshalJSON encodes the parameters returned by Map.
+func (w With) MarshalJSON() ([]byte, error) {
+	return json.Marshal(w.Map())
+}
+
+// Map returns every parameter of the action: Params, and the known
+// parameters that are set, the latter taking precedence.
+func (w With) Map() map[string]interface{} {
+	params := make(map[string]interface{}, len(w.Params)+len(knownParams))
+	for k, v := range w.Params {
+		params[k] = v
+	}
+	fields := reflect.ValueOf(w)
+	for name, i := range knownParams {
+		if field := fields.Field(i); !field.IsZero() {
+			params[name] = field.Interface()
+		}
+	}
+	return params
 }
 
 // ForEach represents a foreach loop in the workflow.
@@ -53,36 +338,107 @@ type Workflow struct {
 	Tasks     []Task      `json:"tasks"`
 	Variables interface{} `json:"variables"`
 	Imports   []Import    `json:"imports,omitempty" yaml:"imports,omitempty"`
+	Timeout   string      `json:"timeout"`
+	OnFailure string      `json:"on-failure"`
+	Plugins   []string    `json:"plugins"`
+	// Env holds environment variables given to every task.
+	Env map[string]interface{} `json:"env"`
+	// SkipPropagation is the default skip-propagation rule of the tasks.
+	SkipPropagation string `json:"skip-propagation"`
+	// File is the absolute path of the workflow file.
+	File string `json:"-"`
+	// VariableSources name where each variable comes from: the workflow
+	// file, an import, or the source given in Options.
+	VariableSources map[string]string `json:"-"`
+}
+
+// Options adjust how a workflow is loaded.
+type Options struct {
+	// Variables override the variables defined in the file.
+	Variables map[string]interface{}
+	// Sources name where each of Variables comes from, such as "-var",
+	// for the dry run. Variables without one are reported as overrides.
+	Sources map[string]string
+	// Lenient renders templates reading undefined variables as "<no value>"
+	// and keeps templates that fail as they are, even in strict workflows.
+	Lenient bool
+	// CheckAction, if set, lets Validate report the actions that are not
+	// built in and cannot be run, such as a plugin missing from pluginDirs.
+	CheckAction func(action string, pluginDirs []string) error
+	// CheckCondition, if set, lets Validate report the when conditions that
+	// do not parse.
+	CheckCondition func(condition string) error
 }
 
 // NewWorkflow loads a workflow from a file, processes it,
 // and returns a pointer to a Workflow struct. It can return an error
 // if there's a problem with marshalling or unmarshalling the data.
 func NewWorkflow(workflowFilePath string) (*Workflow, error) {
+	return NewWorkflowWithOptions(workflowFilePath, Options{})
+}
+
+// NewWorkflowWithOptions loads a workflow like NewWorkflow, adjusted by the
+// given options.
+func NewWorkflowWithOptions(workflowFilePath string, opts Options) (*Workflow, error) {
 	var wf Workflow
 
 	workflowData, err := loadWorkflowFile(workflowFilePath)
 	if err != nil {
 		return nil, fmt.Errorf("error loading workflow: %w", err)
 	}
+	defined := make(map[string]struct{})
+	for name := range variablesOf(workflowData) {
+		defined[name] = struct{}{}
+	}
+	inputs, err := parseInputs(workflowData["inputs"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid inputs: %w", err)
+	}
+	for _, input := range inputs {
+		if _, ok := defined[input.Name]; ok {
+			return nil, fmt.Errorf("invalid inputs: input %s is also a variable", input.Name)
+		}
+	}
+	values, defaulted, err := resolveInputs(inputs, opts.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inputs: %w", err)
+	}
+	overrideVariables(workflowData, opts.Variables)
+	overrideVariables(workflowData, values)
 
 	// Process imports if present
+	imported := make(map[string]string)
 	if imports, ok := workflowData["imports"]; ok {
-		err := processImports(workflowFilePath, imports, workflowData)
+		err := processImports(workflowFilePath, imports, workflowData, imported)
 		if err != nil {
 			return nil, fmt.Errorf("error processing imports: %w", err)
 		}
 	}
+	sources := variableSources(variablesOf(workflowData), defined, imported, opts)
+	for name := range defaulted {
+		sources[name] = SourceInputDefault
+	}
 
-	taskCollection, err := ProcessWorkflow(workflowData)
+	strict, err := isStrict(workflowData)
 	if err != nil {
-		return nil, fmt.Errorf("error processing workflow: %w", err)
+		return nil, err
+	}
+	strict = strict && !opts.Lenient
+	taskCollection, err := processWorkflow(workflowData, strict)
+	env, errs := renderTemplates(workflowData["env"], variablesOf(workflowData), strict, "env")
+	if err != nil || len(errs) > 0 {
+		return nil, fmt.Errorf("error processing workflow: %w", errors.Join(append([]error{err}, errs...)...))
 	}
 
 	// Create a map with the workflow data
 	mapWorkflow := map[string]interface{}{
-		"variables": workflowData["variables"],
-		"tasks":     taskCollection,
+		"variables":        workflowData["variables"],
+		"tasks":            taskCollection,
+		"timeout":          workflowData["timeout"],
+		"on-failure":       workflowData["on-failure"],
+		"plugins":          resolvePluginDirs(workflowFilePath, workflowData["plugins"]),
+		"env":              env,
+		"skip-propagation": workflowData["skip-propagation"],
 	}
 
 	// Convert the map to JSON
@@ -97,12 +453,204 @@ func NewWorkflow(workflowFilePath string) (*Workflow, error) {
 		return nil, fmt.Errorf("error unmarshalling JSON: %w", err)
 	}
 
+	if err := wf.validate(); err != nil {
+		return nil, fmt.Errorf("invalid workflow: %w", err)
+	}
+	if wf.File, err = filepath.Abs(workflowFilePath); err != nil {
+		return nil, err
+	}
+	wf.VariableSources = sources
+
 	return &wf, nil
 }
 
+// overrideVariables replaces the variables of the workflow data by the given
+// ones. It runs before imports, so imported variables do not win over them.
+func overrideVariables(workflowData map[string]interface{}, overrides map[string]interface{}) {
+	if len(overrides) == 0 {
+		return
+	}
+	variables, _ := workflowData["variables"].(map[string]interface{})
+	if variables == nil {
+		variables = make(map[string]interface{}, len(overrides))
+	}
+	for name, value := range overrides {
+		variables[name] = ConvertKeysToString(value)
+	}
+	workflowData["variables"] = variables
+}
+
+// validate checks the workflow settings that cannot be verified while parsing.
+func (wf *Workflow) validate() error {
+	if _, err := ParseTimeout(wf.Timeout); err != nil {
+		return fmt.Errorf("workflow timeout: %w", err)
+	}
+	if wf.OnFailure != "" && !IsValidCancelPolicy(wf.OnFailure) {
+		return fmt.Errorf("unknown on-failure policy %q", wf.OnFailure)
+	}
+	if !IsValidSkipPropagation(wf.SkipPropagation) {
+		return fmt.Errorf("unknown skip-propagation %q", wf.SkipPropagation)
+	}
+	defined := make(map[string]struct{}, len(wf.Tasks))
+	for _, task := range wf.Tasks {
+		if _, ok := defined[task.Name]; ok {
+			return fmt.Errorf("duplicate task name %q", task.Name)
+		}
+		defined[task.Name] = struct{}{}
+		if err := task.validate(); err != nil {
+			return fmt.Errorf("task %s: %w", task.Name, err)
+		}
+	}
+	dependsOn := make(map[string][]string, len(wf.Tasks))
+	for _, task := range wf.Tasks {
+		for _, dependency := range task.DependsOn {
+			if _, ok := defined[dependency]; !ok {
+				return fmt.Errorf("task %s depends on unknown task %q", task.Name, dependency)
+			}
+		}
+		dependsOn[task.Name] = task.DependsOn
+	}
+	// Tasks are rendered with the state of the tasks that have finished, so
+	// only those a task waits for are sure to be there.
+	for _, task := range wf.Tasks {
+		for _, name := range task.TaskReferences() {
+			if _, ok := defined[name]; !ok {
+				return fmt.Errorf("task %s reads unknown task %q in .tasks", task.Name, name)
+			}
+			if !dependsOnTask(dependsOn, task.Name, name) {
+				return fmt.Errorf("task %s reads task %q in .tasks without depending on it: add %q to its depends-on", task.Name, name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// addDependency records that name depends on other, unless other already
+// depends on name, directly or through other tasks, which it reports.
+func addDependency(dependsOn map[string][]string, name string, other string) bool {
+	if dependsOnTask(dependsOn, other, name) {
+		return false
+	}
+	dependsOn[name] = append(dependsOn[name], other)
+	return true
+}
+
+// dependsOnTask reports whether a task depends on another one, directly or
+// through other tasks.
+func dependsOnTask(dependsOn map[string][]string, task string, other string) bool {
+	visited := make(map[string]struct{})
+	pending := append([]string(nil), dependsOn[task]...)
+	for len(pending) > 0 {
+		name := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if name == other {
+			return true
+		}
+		if _, ok := visited[name]; ok {
+			continue
+		}
+		visited[name] = struct{}{}
+		pending = append(pending, dependsOn[name]...)
+	}
+	return false
+}
+
+// validate checks the settings of a task that cannot be verified while
+// parsing.
+func (t *Task) validate() error {
+	if t.OnFailure != "" && !IsValidCancelPolicy(t.OnFailure) {
+		return fmt.Errorf("unknown on-failure policy %q", t.OnFailure)
+	}
+	if _, err := ParseTimeout(t.Timeout); err != nil {
+		return fmt.Errorf("timeout: %w", err)
+	}
+	switch t.CleanupWhen {
+	case "", CleanupAlways, CleanupOnSuccess, CleanupOnFailure, CleanupOnCancel:
+	default:
+		return fmt.Errorf("unknown cleanup-when %q", t.CleanupWhen)
+	}
+	if _, err := t.RetryPolicy(); err != nil {
+		return err
+	}
+	if _, err := ParseInheritEnv(t.Do.With.InheritEnv); err != nil {
+		return err
+	}
+	if _, err := ParseInheritEnv(t.Cleanup.With.InheritEnv); err != nil {
+		return fmt.Errorf("cleanup: %w", err)
+	}
+	if !IsValidTrigger(t.Trigger) {
+		return fmt.Errorf("unknown trigger %q", t.Trigger)
+	}
+	if !IsValidSkipPropagation(t.SkipPropagation) {
+		return fmt.Errorf("unknown skip-propagation %q", t.SkipPropagation)
+	}
+	for _, output := range t.Outputs {
+		if err := output.validate(); err != nil {
+			return err
+		}
+	}
+	for _, input := range t.Inputs {
+		if err := ValidateGlob(input); err != nil {
+			return fmt.Errorf("input %q: %w", input, err)
+		}
+	}
+	if !IsValidFlagStyle(t.Do.With.FlagStyle) {
+		return fmt.Errorf("unknown flag-style %q", t.Do.With.FlagStyle)
+	}
+	if !IsValidFlagStyle(t.Cleanup.With.FlagStyle) {
+		return fmt.Errorf("cleanup: unknown flag-style %q", t.Cleanup.With.FlagStyle)
+	}
+	if !IsValidBoolFlags(t.Do.With.BoolFlags) {
+		return fmt.Errorf("unknown bool-flags %q", t.Do.With.BoolFlags)
+	}
+	if !IsValidBoolFlags(t.Cleanup.With.BoolFlags) {
+		return fmt.Errorf("cleanup: unknown bool-flags %q", t.Cleanup.With.BoolFlags)
+	}
+	return nil
+}
+
+// isStrict reports whether the templates of a workflow are rendered in
+// strict mode, which is the default: reading an undefined variable, or any
+// other template error, makes loading fail. Workflows opt out with
+// "strict: false".
+func isStrict(workflowData map[string]interface{}) (bool, error) {
+	switch strict := workflowData["strict"].(type) {
+	case nil:
+		return true, nil
+	case bool:
+		return strict, nil
+	default:
+		return false, fmt.Errorf("strict must be true or false, got %v", strict)
+	}
+}
+
+// variablesOf returns the variables of raw workflow data, or nil if there
+// are none.
+func variablesOf(workflowData map[string]interface{}) map[string]interface{} {
+	variables, _ := workflowData["variables"].(map[string]interface{})
+	return variables
+}
+
+// ParseTimeout parses a timeout such as "90s" or "5m". An empty string
+// means no timeout and is returned as zero.
+func ParseTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative timeout %q", timeout)
+	}
+	return d, nil
+}
+
 // processImports loads imported workflows and merges their tasks into the main workflow.
 // Imported task names are prefixed with the namespace ("as" field) to avoid collisions.
-func processImports(mainFilePath string, importsRaw interface{}, workflowData map[string]interface{}) error {
+// The variables it adds are recorded in imported, with the file they come from.
+func processImports(mainFilePath string, importsRaw interface{}, workflowData map[string]interface{}, imported map[string]string) error {
 	importsList, ok := importsRaw.([]interface{})
 	if !ok {
 		return fmt.Errorf("imports must be a list")
@@ -148,6 +696,7 @@ func processImports(mainFilePath string, importsRaw interface{}, workflowData ma
 					// Only add if not already defined in main workflow
 					if _, exists := mainVars[k]; !exists {
 						mainVars[k] = v
+						imported[k] = "import " + fileVal
 					}
 				}
 			}
@@ -180,6 +729,24 @@ func processImports(mainFilePath string, importsRaw interface{}, workflowData ma
 	return nil
 }
 
+// resolvePluginDirs makes the plugin directories listed in a workflow file
+// relative to that file.
+func resolvePluginDirs(workflowFilePath string, pluginsRaw interface{}) interface{} {
+	dirs, ok := pluginsRaw.([]interface{})
+	if !ok {
+		return pluginsRaw
+	}
+	resolved := make([]interface{}, len(dirs))
+	for i, dir := range dirs {
+		if s, ok := dir.(string); ok && !filepath.IsAbs(s) {
+			resolved[i] = filepath.Join(filepath.Dir(workflowFilePath), s)
+		} else {
+			resolved[i] = dir
+		}
+	}
+	return resolved
+}
+
 // prefixDependencies adds a namespace prefix to dependency references
 // unless they already contain a dot (already namespaced).
 func prefixDependencies(deps interface{}, namespace string) interface{} {
@@ -214,8 +781,10 @@ func prefixDependencies(deps interface{}, namespace string) interface{} {
 
 // loadWorkflowFile reads a workflow from a file (YAML or JSON),
 // converts all keys to strings, and returns the result as a map.
+// Multi-key maps in action args are split by orderArgs first, so the flags
+// they render keep the order of the file.
 func loadWorkflowFile(filePath string) (map[string]interface{}, error) {
-	data := make(map[string]interface{})
+	var data interface{}
 	file, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("error reading file: %w", err)
@@ -224,20 +793,22 @@ func loadWorkflowFile(filePath string) (map[string]interface{}, error) {
 	ext := strings.ToLower(filepath.Ext(filePath))
 	switch ext {
 	case ".json":
-		err = json.Unmarshal(file, &data)
+		data, err = decodeOrderedJSON(file)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing JSON: %w", err)
 		}
 	case ".yaml", ".yml":
-		err = yaml.Unmarshal(file, &data)
+		var ordered yaml.MapSlice
+		err = yaml.Unmarshal(file, &ordered)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing YAML: %w", err)
 		}
+		data = ordered
 	default:
 		return nil, fmt.Errorf("unsupported file format: %s (use .yaml, .yml, or .json)", ext)
 	}
 
-	converted, ok := ConvertKeysToString(data).(map[string]interface{})
+	converted, ok := ConvertKeysToString(orderArgs(data)).(map[string]interface{})
 	if !ok {
 		return nil, fmt.Errorf("error converting keys to string")
 	}
@@ -245,42 +816,241 @@ func loadWorkflowFile(filePath string) (map[string]interface{}, error) {
 	return converted, nil
 }
 
+// decodeOrderedJSON decodes a JSON document like json.Unmarshal would into
+// an interface{}, except that objects become yaml.MapSlice values keeping the
+// order of their keys.
+func decodeOrderedJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	value, err := decodeOrderedValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the top-level value")
+	}
+	return value, nil
+}
+
+// decodeOrderedValue decodes the next JSON value of dec.
+func decodeOrderedValue(dec *json.Decoder) (interface{}, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		object := yaml.MapSlice{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrderedValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, yaml.MapItem{Key: key, Value: value})
+		}
+		_, err = dec.Token()
+		return object, err
+	case json.Delim('['):
+		list := []interface{}{}
+		for dec.More() {
+			value, err := decodeOrderedValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err = dec.Token()
+		return list, err
+	}
+	return token, nil
+}
+
+// orderArgs walks a workflow decoded with its key order and, in the args of
+// every `with` block, replaces each map that has several keys by one
+// single-key map per entry, in source order.
+func orderArgs(item interface{}) interface{} {
+	switch x := item.(type) {
+	case yaml.MapSlice:
+		for i := range x {
+			if with, ok := x[i].Value.(yaml.MapSlice); ok && x[i].Key == "with" {
+				for j := range with {
+					if args, ok := with[j].Value.([]interface{}); ok && with[j].Key == "args" {
+						with[j].Value = splitArgMaps(args)
+					}
+				}
+			}
+			x[i].Value = orderArgs(x[i].Value)
+		}
+	case []interface{}:
+		for i := range x {
+			x[i] = orderArgs(x[i])
+		}
+	}
+	return item
+}
+
+// splitArgMaps splits the multi-key maps of an args list.
+func splitArgMaps(args []interface{}) []interface{} {
+	split := make([]interface{}, 0, len(args))
+	for _, arg := range args {
+		if m, ok := arg.(yaml.MapSlice); ok && len(m) > 1 {
+			for _, item := range m {
+				split = append(split, yaml.MapSlice{item})
+			}
+			continue
+		}
+		split = append(split, arg)
+	}
+	return split
+}
+
 // ProcessWorkflow processes the raw workflow data and returns a collection of tasks.
-// It can return an error if there's a problem with parsing the variables or tasks.
+// It returns an error if the variables or tasks are malformed, if a foreach
+// loop cannot be expanded or, unless the workflow sets "strict: false", if a
+// template cannot be rendered.
 func ProcessWorkflow(workflowRawData map[string]interface{}) ([]map[string]interface{}, error) {
+	strict, err := isStrict(workflowRawData)
+	if err != nil {
+		return nil, err
+	}
+	return processWorkflow(workflowRawData, strict)
+}
+
+// processWorkflow is ProcessWorkflow, rendering templates in strict mode or
+// not. It reports the template errors of every task at once.
+func processWorkflow(workflowRawData map[string]interface{}, strict bool) ([]map[string]interface{}, error) {
 	taskCollection := []map[string]interface{}{}
 
-	// Convert the interface keys to string and separate the variables from the tasks
+	// Separate the variables from the tasks. A workflow may define no variables.
 	variables, ok := workflowRawData["variables"].(map[string]interface{})
-	if !ok {
-		fmt.Println("Error parsing variables.")
-		return nil, fmt.Errorf("error parsing variables")
+	if !ok && workflowRawData["variables"] != nil {
+		return nil, fmt.Errorf("variables must be a map")
 	}
 	tasks, ok := workflowRawData["tasks"].([]interface{})
 	if !ok {
-		fmt.Println("Error parsing tasks.")
-		return nil, fmt.Errorf("error parsing tasks")
+		return nil, fmt.Errorf("tasks must be a list")
+	}
+	if err := renderVariables(variables, strict); err != nil {
+		return nil, err
 	}
 
 	// Analyze the workflow data and creates the corresponding tasks.
-	for _, task := range tasks {
-		if _, ok := task.(map[string]interface{})["foreach"]; ok {
-			newTasks := ExpandTask(task, variables)
-			taskCollection = append(taskCollection, newTasks...)
-		} else {
-			// This task does not have a 'foreach' field, so we just need to replace the placeholders.
-			taskToAdd := ReplacePlaceholders(task, variables)
-			taskCollection = append(taskCollection, taskToAdd.(map[string]interface{}))
+	var errs []error
+	for i, task := range tasks {
+		taskMap, ok := task.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("task %d must be a map", i+1)
+		}
+		// A task without a 'foreach' field is rendered once, with the variables.
+		bindings := []map[string]interface{}{variables}
+		if _, ok := taskMap["foreach"]; ok {
+			var err error
+			if bindings, err = foreachBindings(taskMap["foreach"], variables); err != nil {
+				errs = append(errs, fmt.Errorf("task %v: %w", taskMap["name"], err))
+				continue
+			}
+		}
+		newTasks, err := renderTasks(taskMap, bindings, strict)
+		if err != nil {
+			errs = append(errs, err)
+			continue
 		}
+		taskCollection = append(taskCollection, newTasks...)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
 	}
 
 	return taskCollection, nil
 }
 
+// renderVariables renders, in place, the templates in the definitions of the
+// variables, which may use the template functions and read other variables.
+// A variable is rendered after the variables it reads, and variables reading
+// each other are an error.
+func renderVariables(variables map[string]interface{}, strict bool) error {
+	const (
+		rendering = 1
+		rendered  = 2
+	)
+	state := make(map[string]int, len(variables))
+	var errs []error
+	var render func(name string, path []string)
+	render = func(name string, path []string) {
+		switch state[name] {
+		case rendered:
+			return
+		case rendering:
+			errs = append(errs, fmt.Errorf("variables %s read each other", strings.Join(append(path, name), " -> ")))
+			return
+		}
+		state[name] = rendering
+		for _, field := range templateVariables(variables[name]) {
+			if _, ok := variables[field]; ok {
+				render(field, append(path, name))
+			}
+		}
+		value, valueErrs := renderTemplates(variables[name], variables, strict, "variables."+name)
+		variables[name] = value
+		errs = append(errs, valueErrs...)
+		state[name] = rendered
+	}
+
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		render(name, nil)
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// templateVariables returns the variables read by the templates of a value.
+func templateVariables(item interface{}) []string {
+	var names []string
+	switch x := item.(type) {
+	case map[string]interface{}:
+		for _, v := range x {
+			names = append(names, templateVariables(v)...)
+		}
+	case []interface{}:
+		for _, v := range x {
+			names = append(names, templateVariables(v)...)
+		}
+	case string:
+		if !strings.Contains(x, "{{") {
+			return nil
+		}
+		if tmpl, err := newTemplate("variable").Parse(x); err == nil {
+			names = templateFields(tmpl.Tree.Root)
+		}
+	}
+	return names
+}
+
 // ConvertKeysToString recursively converts all keys in the input
 // to strings if they are not already.
 func ConvertKeysToString(item interface{}) interface{} {
 	switch x := item.(type) {
+	case yaml.MapSlice:
+		m2 := map[string]interface{}{}
+		for _, entry := range x {
+			ks, ok := entry.Key.(string)
+			if !ok {
+				fmt.Println("Key" + fmt.Sprint(entry.Key) + "is not string type. Forcing conversion.")
+				ks = fmt.Sprint(entry.Key)
+			}
+			m2[ks] = ConvertKeysToString(entry.Value)
+		}
+		return m2
 	case map[interface{}]interface{}:
 		m2 := map[string]interface{}{}
 		for k, v := range x {
@@ -319,118 +1089,408 @@ func ConvertKeysToString(item interface{}) interface{} {
 }
 
 // ExpandTask expands a task with foreach loops into multiple tasks based on the variables.
-func ExpandTask(task interface{}, variables map[string]interface{}) []map[string]interface{} {
+// It returns an error if a loop is malformed or its variable is not a defined list.
+// Templates are rendered leniently, like ReplacePlaceholders does.
+func ExpandTask(task interface{}, variables map[string]interface{}) ([]map[string]interface{}, error) {
 	taskMap, ok := task.(map[string]interface{})
 	if !ok {
-		return nil
+		return nil, fmt.Errorf("the task must be a map")
 	}
-	iterator, ok := taskMap["foreach"].([]interface{})
-	if !ok {
-		return nil
+	bindings, err := foreachBindings(taskMap["foreach"], variables)
+	if err != nil {
+		return nil, err
 	}
-	foreachMap := make([]map[string]interface{}, len(iterator))
+	return renderTasks(taskMap, bindings, false)
+}
 
-	for i, v := range iterator {
-		mapValue, ok := v.(map[string]interface{})
-		if !ok {
-			fmt.Printf("Error parsing foreach field: %v\n", v)
-			return nil
+// renderTasks renders a task once for each set of variables, without its
+// 'foreach' key. In strict mode, the template errors of every rendering are
+// returned, naming the task and field they were found in.
+func renderTasks(taskMap map[string]interface{}, bindings []map[string]interface{}, strict bool) ([]map[string]interface{}, error) {
+	// Clone the task map without the 'foreach' key to avoid mutating the original.
+	cleanTask := make(map[string]interface{})
+	for k, v := range taskMap {
+		if k != "foreach" {
+			cleanTask[k] = v
 		}
-		foreachMap[i] = mapValue
 	}
 
-	newTasks := []map[string]interface{}{}
-	variableNames := make([]string, len(foreachMap))
-	asIdentifiers := make([]string, len(foreachMap))
+	loops, _ := taskMap["foreach"].([]interface{})
 
-	for i, loop := range foreachMap {
-		variableNames[i] = loop["variable"].(string)
-		asIdentifiers[i] = loop["as"].(string)
+	newTasks := []map[string]interface{}{}
+	var errs []error
+	for _, variablesWithItems := range bindings {
+		// Replace the placeholders in the task with the actual values
+		rendered, taskErrs := renderTemplates(cleanTask, variablesWithItems, strict, "")
+		taskToAdd := rendered.(map[string]interface{})
+		// Templates reading .tasks are rendered at launch, with the items.
+		if encoded, err := json.Marshal(taskToAdd); err == nil && taskReference.Match(encoded) {
+			items := make(map[string]interface{}, len(loops))
+			for _, loop := range loops {
+				entry, _ := loop.(map[string]interface{})
+				if as, ok := entry["as"].(string); ok {
+					items[as] = variablesWithItems[as]
+				}
+			}
+			if len(items) > 0 {
+				taskToAdd["foreach-items"] = items
+			}
+		}
+		for _, err := range taskErrs {
+			name, ok := taskToAdd["name"].(string)
+			if !ok || strings.Contains(name, "{{") {
+				name = fmt.Sprint(cleanTask["name"])
+			}
+			errs = append(errs, fmt.Errorf("task %s: %w", name, err))
+		}
+		newTasks = append(newTasks, taskToAdd)
 	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return newTasks, nil
+}
 
-	variableValues := make([][]interface{}, len(variableNames))
+// foreachBindings returns the variables of each task generated by foreach
+// loops: the workflow variables with one combination of the items of the
+// loops, each bound to the "as" name of its loop.
+func foreachBindings(foreach interface{}, variables map[string]interface{}) ([]map[string]interface{}, error) {
+	iterator, ok := foreach.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("foreach must be a list")
+	}
 
-	for i, name := range variableNames {
-		value, ok := variables[name]
+	asIdentifiers := make([]string, len(iterator))
+	variableValues := make([][]interface{}, len(iterator))
+	for i, v := range iterator {
+		loop, ok := v.(map[string]interface{})
 		if !ok {
-			fmt.Printf("Error: variable %q not found\n", name)
-			return nil
+			return nil, fmt.Errorf("foreach entries must be maps with variable and as keys, got %v", v)
+		}
+		name, _ := loop["variable"].(string)
+		as, _ := loop["as"].(string)
+		if name == "" || as == "" {
+			return nil, fmt.Errorf("foreach entries need a variable and an as name")
 		}
-		vals, ok := value.([]interface{})
+		value, ok := variables[name]
 		if !ok {
-			fmt.Printf("Error: variable %q is not a list\n", name)
-			return nil
+			return nil, fmt.Errorf("foreach variable %q is not defined", name)
 		}
-		variableValues[i] = vals
-	}
-
-	// Clone the task map without the 'foreach' key to avoid mutating the original.
-	cleanTask := make(map[string]interface{})
-	for k, v := range taskMap {
-		if k != "foreach" {
-			cleanTask[k] = v
+		values, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("foreach variable %q is not a list", name)
 		}
+		asIdentifiers[i] = as
+		variableValues[i] = values
 	}
 
+	bindings := []map[string]interface{}{}
 	for _, combination := range product(variableValues) {
-		variablesWithItems := make(map[string]interface{})
+		variablesWithItems := make(map[string]interface{}, len(variables)+len(combination))
 		for k, v := range variables {
 			variablesWithItems[k] = v
 		}
 		for i, v := range combination {
 			variablesWithItems[asIdentifiers[i]] = v
 		}
-		// Replace the placeholders in the task with the actual values
-		taskToAdd := ReplacePlaceholders(cleanTask, variablesWithItems).(map[string]interface{})
-		newTasks = append(newTasks, taskToAdd)
+		bindings = append(bindings, variablesWithItems)
 	}
-	return newTasks
+	return bindings, nil
 }
 
 // ReplacePlaceholders replaces placeholders in the item with actual values from the variables.
+// Undefined variables render as "<no value>", and templates that fail to
+// render are printed and kept as they are.
 func ReplacePlaceholders(item interface{}, variables map[string]interface{}) interface{} {
+	rendered, _ := renderTemplates(item, variables, false, "")
+	return rendered
+}
+
+// renderTemplates replaces placeholders like ReplacePlaceholders. In strict
+// mode, reading an undefined variable is an error, and the errors are
+// returned, sorted, each naming the field it was found in: field itself for
+// item, and its keys and indexes below it.
+func renderTemplates(item interface{}, variables map[string]interface{}, strict bool, field string) (interface{}, []error) {
+	var errs []error
 	switch x := item.(type) {
 	case map[string]interface{}:
 		m2 := map[string]interface{}{}
 		for k, v := range x {
-			m2[k] = ReplacePlaceholders(v, variables)
+			var itemErrs []error
+			m2[k], itemErrs = renderTemplates(v, variables, strict, fieldKey(field, k))
+			errs = append(errs, itemErrs...)
 		}
-		return m2
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+		return m2, errs
 	case map[interface{}]interface{}:
 		m2 := map[string]interface{}{}
 		for k, v := range x {
-			m2[k.(string)] = ReplacePlaceholders(v, variables)
+			var itemErrs []error
+			m2[k.(string)], itemErrs = renderTemplates(v, variables, strict, fieldKey(field, k.(string)))
+			errs = append(errs, itemErrs...)
 		}
-		return m2
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+		return m2, errs
 	case []interface{}:
 		i2 := make([]interface{}, len(x))
 		for i, v := range x {
-			i2[i] = ReplacePlaceholders(v, variables)
+			var itemErrs []error
+			i2[i], itemErrs = renderTemplates(v, variables, strict, fmt.Sprintf("%s[%d]", field, i))
+			errs = append(errs, itemErrs...)
 		}
-		return i2
+		return i2, errs
 	case []map[interface{}]interface{}:
 		i2 := make([]map[string]interface{}, len(x))
 		for i, v := range x {
-			i2[i] = ReplacePlaceholders(v, variables).(map[string]interface{})
+			rendered, itemErrs := renderTemplates(v, variables, strict, fmt.Sprintf("%s[%d]", field, i))
+			i2[i] = rendered.(map[string]interface{})
+			errs = append(errs, itemErrs...)
 		}
-		return i2
+		return i2, errs
 	case string:
-		temp := template.New("workflow")
+		if taskReference.MatchString(x) {
+			return x, deferredTemplateErrors(x, variables, strict, field)
+		}
+		temp := newTemplate("workflow")
+		if strict {
+			temp = temp.Option("missingkey=error")
+		}
 		temp, err := temp.Parse(x)
 		if err != nil {
+			if strict {
+				return x, []error{fmt.Errorf("%s: invalid template %q: %s", field, x, templateError.ReplaceAllString(err.Error(), ""))}
+			}
 			fmt.Printf("Error parsing template %q: %v\n", x, err)
-			return x
+			return x, nil
 		}
 		buf := &bytes.Buffer{}
 		err = temp.Execute(buf, variables)
 		if err != nil {
+			if strict {
+				return x, []error{fmt.Errorf("%s: template %q: %s", field, x, templateError.ReplaceAllString(err.Error(), ""))}
+			}
 			fmt.Printf("Error executing template %q: %v\n", x, err)
-			return x
+			return x, nil
 		}
-		return buf.String()
+		return buf.String(), nil
 	}
 
-	return item
+	return item, nil
+}
+
+// fieldKey names the field under key of a field.
+func fieldKey(field string, key string) string {
+	if field == "" {
+		return key
+	}
+	return field + "." + key
+}
+
+// taskReference matches the template actions that read the state of other
+// tasks, which is only known once they have run.
+var taskReference = regexp.MustCompile(`\{\{[^{}]*\.tasks\b[^{}]*\}\}`)
+
+// deferredTemplateErrors checks a template reading .tasks, which is kept
+// whole for RenderTask: in strict mode, it must parse and only read defined
+// variables besides .tasks.
+func deferredTemplateErrors(s string, variables map[string]interface{}, strict bool, field string) []error {
+	if !strict {
+		return nil
+	}
+	temp, err := newTemplate("workflow").Parse(s)
+	if err != nil {
+		return []error{fmt.Errorf("%s: invalid template %q: %s", field, s, templateError.ReplaceAllString(err.Error(), ""))}
+	}
+	for _, name := range templateFields(temp.Root) {
+		if _, ok := variables[name]; !ok && name != "tasks" {
+			return []error{fmt.Errorf("%s: template %q: map has no entry for key %q", field, s, name)}
+		}
+	}
+	return nil
+}
+
+// ReferencesTasks reports whether a task reads the state of other tasks in
+// its templates, and so must be rendered with RenderTask before it runs.
+func (t *Task) ReferencesTasks() bool {
+	data, err := json.Marshal(t)
+	return err == nil && taskReference.Match(data)
+}
+
+// TaskReferences returns the names of the tasks a task reads in its
+// templates, as {{.tasks.<name>...}} or {{index .tasks "<name>" ...}}, in
+// the order they appear. Templates that do not parse are left out.
+func (t *Task) TaskReferences() []string {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil
+	}
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil
+	}
+	var names []string
+	seen := make(map[string]struct{})
+	var walk func(item interface{})
+	walk = func(item interface{}) {
+		switch x := item.(type) {
+		case map[string]interface{}:
+			keys := make([]string, 0, len(x))
+			for k := range x {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				walk(x[k])
+			}
+		case []interface{}:
+			for _, v := range x {
+				walk(v)
+			}
+		case string:
+			if !taskReference.MatchString(x) {
+				return
+			}
+			temp, err := newTemplate("task").Parse(x)
+			if err != nil {
+				return
+			}
+			for _, name := range referencedTasks(temp.Root) {
+				if _, ok := seen[name]; !ok {
+					seen[name] = struct{}{}
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	walk(raw)
+	return names
+}
+
+// referencedTasks returns the task names read from .tasks in a template.
+func referencedTasks(node parse.Node) []string {
+	var names []string
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			names = append(names, referencedTasks(child)...)
+		}
+	case *parse.ActionNode:
+		names = referencedTasks(n.Pipe)
+	case *parse.IfNode:
+		names = append(referencedTasks(n.Pipe), referencedTasks(n.List)...)
+		names = append(names, referencedTasks(n.ElseList)...)
+	case *parse.RangeNode:
+		names = append(referencedTasks(n.Pipe), referencedTasks(n.List)...)
+		names = append(names, referencedTasks(n.ElseList)...)
+	case *parse.WithNode:
+		names = append(referencedTasks(n.Pipe), referencedTasks(n.List)...)
+		names = append(names, referencedTasks(n.ElseList)...)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			names = append(names, referencedTasks(cmd)...)
+		}
+	case *parse.CommandNode:
+		// index .tasks "name" ...
+		if len(n.Args) >= 3 {
+			if fn, ok := n.Args[0].(*parse.IdentifierNode); ok && fn.Ident == "index" {
+				field, isField := n.Args[1].(*parse.FieldNode)
+				name, isString := n.Args[2].(*parse.StringNode)
+				if isField && isString && len(field.Ident) == 1 && field.Ident[0] == "tasks" {
+					names = append(names, name.Text)
+				}
+			}
+		}
+		for _, arg := range n.Args {
+			names = append(names, referencedTasks(arg)...)
+		}
+	case *parse.FieldNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "tasks" {
+			names = n.Ident[1:2]
+		}
+	}
+	return names
+}
+
+// RenderTask renders the templates left in a task after loading, the ones
+// reading .tasks, with the given data and the foreach items of the task.
+// Unlike ReplacePlaceholders it fails on templates that cannot be rendered,
+// including references to missing keys.
+func RenderTask(task Task, data map[string]interface{}) (Task, error) {
+	items := task.ForEachItems
+	task.ForEachItems = nil
+	encoded, err := json.Marshal(task)
+	if err != nil {
+		return task, err
+	}
+	var raw interface{}
+	if err := json.Unmarshal(encoded, &raw); err != nil {
+		return task, err
+	}
+	if len(items) > 0 {
+		withItems := make(map[string]interface{}, len(data)+len(items))
+		for k, v := range data {
+			withItems[k] = v
+		}
+		for k, v := range items {
+			withItems[k] = v
+		}
+		data = withItems
+	}
+	rendered, err := renderStrings(raw, data)
+	if err != nil {
+		return task, err
+	}
+	if encoded, err = json.Marshal(rendered); err != nil {
+		return task, err
+	}
+	var result Task
+	if err := json.Unmarshal(encoded, &result); err != nil {
+		return task, err
+	}
+	result.ForEachItems = items
+	return result, nil
+}
+
+// renderStrings renders every string holding a template in item.
+func renderStrings(item interface{}, data map[string]interface{}) (interface{}, error) {
+	switch x := item.(type) {
+	case map[string]interface{}:
+		for k, v := range x {
+			rendered, err := renderStrings(v, data)
+			if err != nil {
+				return nil, err
+			}
+			x[k] = rendered
+		}
+	case []interface{}:
+		for i, v := range x {
+			rendered, err := renderStrings(v, data)
+			if err != nil {
+				return nil, err
+			}
+			x[i] = rendered
+		}
+	case string:
+		if !taskReference.MatchString(x) {
+			return x, nil
+		}
+		temp, err := newTemplate("task").Option("missingkey=error").Parse(x)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing template %q: %w", x, err)
+		}
+		buf := &bytes.Buffer{}
+		if err := temp.Execute(buf, data); err != nil {
+			return nil, fmt.Errorf("error executing template %q: %w", x, err)
+		}
+		return buf.String(), nil
+	}
+	return item, nil
 }
 
 // product generates the Cartesian product of a slice of slices.
@@ -125,6 +125,69 @@ func TestSetStatusCanceled(t *testing.T) {
 	}
 }
 
+func TestSetStatusTimedOut(t *testing.T) {
+	taskCollection := []map[string]interface{}{
+		{
+			"task":       "a",
+			"depends-on": []string{},
+		},
+	}
+	d := dag.NewDAG(taskCollection, false)
+	d.SetStatus("a", "timed-out")
+	if d.GetStatus("a") != "timed-out" {
+		t.Errorf("GetStatus returned: %v, expected: timed-out", d.GetStatus("a"))
+	}
+}
+
+func TestSetStatusSkipped(t *testing.T) {
+	taskCollection := []map[string]interface{}{
+		{
+			"task":       "a",
+			"depends-on": []string{},
+		},
+		{
+			"task":       "b",
+			"depends-on": []string{"a"},
+		},
+	}
+	d := dag.NewDAG(taskCollection, false)
+	d.SetStatus("a", "skipped")
+	if d.GetStatus("a") != "skipped" {
+		t.Errorf("GetStatus returned: %v, expected: skipped", d.GetStatus("a"))
+	}
+	if !d.IsReady("b") {
+		t.Error("A task whose dependency was skipped should be ready")
+	}
+	d.CancelDependentTasks("b", "abort-all")
+	if _, ok := d.GetTasksToCancel()["a"]; ok && d.GetStatus("a") != "skipped" {
+		t.Error("Canceling must not change the status of a skipped task")
+	}
+}
+
+func TestIsReady(t *testing.T) {
+	taskCollection := []map[string]interface{}{
+		{
+			"task":       "a",
+			"depends-on": []string{"b"},
+		},
+		{
+			"task":       "b",
+			"depends-on": []string{},
+		},
+	}
+	d := dag.NewDAG(taskCollection, false)
+	if !d.IsReady("b") {
+		t.Error("Task b has no dependencies and should be ready")
+	}
+	if d.IsReady("a") {
+		t.Error("Task a should not be ready while b is pending")
+	}
+	d.SetStatus("b", "failed")
+	if !d.IsReady("a") {
+		t.Error("Task a should be ready once b has finished")
+	}
+}
+
 func TestCancelTask(t *testing.T) {
 	taskCollection := []map[string]interface{}{
 		{
@@ -393,3 +456,134 @@ func TestGetExecutionPlanComplex(t *testing.T) {
 		t.Errorf("GetExecutionPlan returned: %v, expected: %v", executionPlan, expected)
 	}
 }
+
+func TestOnStatusChange(t *testing.T) {
+	d := dag.NewDAG([]map[string]interface{}{{"task": "a", "depends-on": []string{}}}, false)
+	var changes []string
+	d.OnStatusChange(func(taskName string, status string) {
+		// The observer runs outside of the lock, so it can query the DAG.
+		changes = append(changes, taskName+"="+d.GetStatus(taskName))
+	})
+	d.SetStatus("a", "successful")
+	d.OnStatusChange(nil)
+	d.SetStatus("a", "failed")
+	if !reflect.DeepEqual(changes, []string{"a=successful"}) {
+		t.Errorf("Observer saw %v, expected [a=successful]", changes)
+	}
+}
+
+func TestIsReadyTriggers(t *testing.T) {
+	taskCollection := []map[string]interface{}{
+		{"task": "a", "depends-on": []string{}},
+		{"task": "b", "depends-on": []string{}},
+		{"task": "alert", "depends-on": []string{"a", "b"}, "trigger": "one-failed"},
+		{"task": "report", "depends-on": []string{"a", "b"}, "trigger": "all-done"},
+		{"task": "audit", "depends-on": []string{"a", "b"}, "trigger": "always"},
+	}
+	d := dag.NewDAG(taskCollection, false)
+	if d.IsReady("alert") || d.IsReady("report") {
+		t.Error("one-failed and all-done tasks should wait while no parent has failed")
+	}
+	if !d.IsReady("audit") {
+		t.Error("An always task should not wait for its parents")
+	}
+	d.SetStatus("a", "failed")
+	if !d.IsReady("alert") {
+		t.Error("A one-failed task should be ready as soon as a parent failed")
+	}
+	if d.IsReady("report") {
+		t.Error("An all-done task should wait for all of its parents")
+	}
+	d.SetStatus("b", "canceled")
+	if !d.IsReady("report") {
+		t.Error("An all-done task should be ready once all of its parents finished")
+	}
+	statuses := d.ParentStatuses("report")
+	if !reflect.DeepEqual(statuses, map[string]string{"a": "failed", "b": "canceled"}) {
+		t.Errorf("ParentStatuses returned %v", statuses)
+	}
+}
+
+func TestTriggerSatisfied(t *testing.T) {
+	taskCollection := []map[string]interface{}{
+		{"task": "a", "depends-on": []string{}},
+		{"task": "b", "depends-on": []string{}},
+		{"task": "strict", "depends-on": []string{"a", "b"}, "trigger": "all-success"},
+		{"task": "default", "depends-on": []string{"a", "b"}},
+		{"task": "alert", "depends-on": []string{"a", "b"}, "trigger": "one-failed"},
+		{"task": "report", "depends-on": []string{"a", "b"}, "trigger": "all-done"},
+	}
+	d := dag.NewDAG(taskCollection, false)
+	d.SetStatus("a", "successful")
+	d.SetStatus("b", "skipped")
+	if !d.TriggerSatisfied("strict") || d.TriggerSatisfied("alert") || !d.TriggerSatisfied("report") {
+		t.Error("With successful and skipped parents only all-success and all-done should be satisfied")
+	}
+
+	d = dag.NewDAG(taskCollection, false)
+	d.SetStatus("a", "successful")
+	d.SetStatus("b", "timed-out")
+	if d.TriggerSatisfied("strict") || !d.TriggerSatisfied("alert") || !d.TriggerSatisfied("report") {
+		t.Error("With a timed-out parent all-success should not be satisfied, one-failed and all-done should")
+	}
+	if !d.TriggerSatisfied("default") {
+		t.Error("Without an explicit trigger the cancel policies decide")
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	taskCollection := []map[string]interface{}{
+		{"task": "a", "depends-on": []string{}},
+		{"task": "b", "depends-on": []string{"a"}},
+		{"task": "c", "depends-on": []string{"b"}},
+		{"task": "d", "depends-on": []string{}},
+	}
+	d := dag.NewDAG(taskCollection, false)
+	if deps := d.Dependencies("c"); !reflect.DeepEqual(deps, []string{"a", "b"}) {
+		t.Errorf("Dependencies returned %v, expected [a b]", deps)
+	}
+	if deps := d.Dependencies("d"); len(deps) != 0 {
+		t.Errorf("Dependencies returned %v, expected none", deps)
+	}
+}
+
+func TestUpToDateCountsAsSuccess(t *testing.T) {
+	taskCollection := []map[string]interface{}{
+		{"task": "a", "depends-on": []string{}},
+		{"task": "b", "depends-on": []string{"a"}, "trigger": "all-success"},
+		{"task": "c", "depends-on": []string{}},
+	}
+	d := dag.NewDAG(taskCollection, false)
+	d.SetStatus("a", "up-to-date")
+	if status := d.GetStatus("a"); status != "up-to-date" {
+		t.Errorf("GetStatus returned: %v, expected: up-to-date", status)
+	}
+	if !d.IsReady("b") || !d.TriggerSatisfied("b") {
+		t.Error("A task whose dependency is up to date should run under all-success")
+	}
+	d.CancelDependentTasks("c", "abort-all")
+	if _, ok := d.GetTasksToCancel()["a"]; ok {
+		t.Error("An up to date task should not be canceled")
+	}
+}
+
+func TestCancelDependentTasksSparesTriggerRules(t *testing.T) {
+	taskCollection := []map[string]interface{}{
+		{"task": "a", "depends-on": []string{}},
+		{"task": "b", "depends-on": []string{"a"}},
+		{"task": "alert", "depends-on": []string{"a"}, "trigger": "one-failed"},
+		{"task": "strict", "depends-on": []string{"a"}, "trigger": "all-success"},
+	}
+	d := dag.NewDAG(taskCollection, false)
+	d.SetStatus("a", "failed")
+	d.CancelDependentTasks("a", "abort-all")
+	tasksToCancel := d.GetTasksToCancel()
+	if _, ok := tasksToCancel["alert"]; ok {
+		t.Error("A task with a one-failed trigger should not be canceled by a cancel policy")
+	}
+	for _, name := range []string{"b", "strict"} {
+		if _, ok := tasksToCancel[name]; !ok {
+			t.Errorf("%s should be canceled by the abort-all policy", name)
+		}
+	}
+}
@@ -3,8 +3,16 @@ package tests
 
 import (
 	"gotasker/src/engine"
+	"gotasker/src/runner"
+	"gotasker/src/state"
 	"gotasker/src/workflow"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func newTestWorkflow(tasks []workflow.Task) *workflow.Workflow {
@@ -72,12 +80,15 @@ func TestExecuteTask(t *testing.T) {
 	if err != nil {
 		t.Fatalf("NewEngine error: %v", err)
 	}
-	output, err := eng.ExecuteTask(&wf.Tasks[0])
+	result, err := eng.ExecuteTask(&wf.Tasks[0])
 	if err != nil {
 		t.Errorf("ExecuteTask returned error: %v", err)
 	}
-	if output == "" {
-		t.Error("ExecuteTask returned empty output")
+	if result == nil || result.Stdout != "hello world\n" || result.ExitCode != 0 {
+		t.Errorf("ExecuteTask returned %+v, expected the echoed stdout and exit code 0", result)
+	}
+	if eng.GetResult("echo-test") != result {
+		t.Error("ExecuteTask did not record the result in the engine")
 	}
 }
 
@@ -170,6 +181,28 @@ func TestRunSimpleWorkflow(t *testing.T) {
 	}
 }
 
+func TestRunRejectsNoThreads(t *testing.T) {
+	wf := newTestWorkflow([]workflow.Task{
+		{
+			Name: "step-1",
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "echo", Args: []interface{}{"step 1"}},
+			},
+		},
+	})
+	eng, err := engine.NewEngine(wf, 0, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	if err := eng.Run(); err == nil || !strings.Contains(err.Error(), "threads") {
+		t.Errorf("Run returned %v, expected an error about the number of threads", err)
+	}
+	if status := eng.DAG.GetStatus("step-1"); status != "pending" {
+		t.Errorf("step-1 status: %s, expected pending", status)
+	}
+}
+
 func TestRunDryRun(t *testing.T) {
 	wf := newTestWorkflow([]workflow.Task{
 		{
@@ -287,3 +320,952 @@ func TestRunParallelIndependentTasks(t *testing.T) {
 		}
 	}
 }
+
+func TestRunStartsReadyTasksWithoutWaitingForLayer(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "slow-done")
+	wf := newTestWorkflow([]workflow.Task{
+		{
+			Name: "slow",
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "sh", Args: []interface{}{"-c", "sleep 1; touch " + marker}},
+			},
+		},
+		{
+			Name: "fast",
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "echo", Args: []interface{}{"fast"}},
+			},
+		},
+		{
+			Name:      "after-fast",
+			DependsOn: []string{"fast"},
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "test", Args: []interface{}{"!", "-e", marker}},
+			},
+		},
+	})
+	eng, err := engine.NewEngine(wf, 3, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	if err := eng.Run(); err != nil {
+		t.Errorf("Run returned error: %v", err)
+	}
+	if status := eng.DAG.GetStatus("after-fast"); status != "successful" {
+		t.Errorf("after-fast status: %s, expected successful (it should not wait for slow)", status)
+	}
+}
+
+func TestRunTaskTimeout(t *testing.T) {
+	wf := newTestWorkflow([]workflow.Task{
+		{
+			Name:    "hangs",
+			Timeout: "200ms",
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "sleep", Args: []interface{}{"10"}},
+			},
+		},
+		{
+			Name:      "after-hang",
+			DependsOn: []string{"hangs"},
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "echo", Args: []interface{}{"should not run"}},
+			},
+		},
+	})
+	eng, err := engine.NewEngine(wf, 2, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	start := time.Now()
+	_ = eng.Run()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run took %s, the timeout did not kill the process", elapsed)
+	}
+	if status := eng.DAG.GetStatus("hangs"); status != "timed-out" {
+		t.Errorf("hangs status: %s, expected timed-out", status)
+	}
+	if status := eng.DAG.GetStatus("after-hang"); status != "canceled" {
+		t.Errorf("after-hang status: %s, expected canceled", status)
+	}
+}
+
+func TestRunWorkflowTimeout(t *testing.T) {
+	wf := newTestWorkflow([]workflow.Task{
+		{
+			Name: "hangs",
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "sleep", Args: []interface{}{"10"}},
+			},
+		},
+	})
+	wf.Timeout = "200ms"
+	eng, err := engine.NewEngine(wf, 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	if err := eng.Run(); err == nil {
+		t.Error("Run should return an error when the workflow times out")
+	}
+	if status := eng.DAG.GetStatus("hangs"); status != "timed-out" {
+		t.Errorf("hangs status: %s, expected timed-out", status)
+	}
+}
+
+func TestNewEngineInvalidTimeout(t *testing.T) {
+	wf := newTestWorkflow(nil)
+	wf.Timeout = "soon"
+	if _, err := engine.NewEngine(wf, 1, false); err == nil {
+		t.Error("NewEngine should reject an invalid workflow timeout")
+	}
+}
+
+func TestRunRetriesFlakyTask(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "attempts")
+	// Fails on the first attempt and succeeds on the second one.
+	script := "echo x >> " + counter + "; test $(wc -l < " + counter + ") -ge 2"
+	wf := newTestWorkflow([]workflow.Task{
+		{
+			Name:       "flaky",
+			Retries:    2,
+			RetryDelay: "10ms",
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "sh", Args: []interface{}{"-c", script}},
+			},
+		},
+	})
+	eng, err := engine.NewEngine(wf, 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	if err := eng.Run(); err != nil {
+		t.Errorf("Run returned error: %v", err)
+	}
+	if status := eng.DAG.GetStatus("flaky"); status != "successful" {
+		t.Errorf("flaky status: %s, expected successful", status)
+	}
+	if attempts := eng.DAG.GetAttempts("flaky"); attempts != 2 {
+		t.Errorf("flaky attempts: %d, expected 2", attempts)
+	}
+}
+
+func TestRunRetryOnUnmatchedExitCode(t *testing.T) {
+	wf := newTestWorkflow([]workflow.Task{
+		{
+			Name:    "fails-with-3",
+			Retries: 3,
+			RetryOn: []interface{}{75},
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "sh", Args: []interface{}{"-c", "exit 3"}},
+			},
+		},
+	})
+	eng, err := engine.NewEngine(wf, 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	_ = eng.Run()
+	if status := eng.DAG.GetStatus("fails-with-3"); status != "failed" {
+		t.Errorf("fails-with-3 status: %s, expected failed", status)
+	}
+	if attempts := eng.DAG.GetAttempts("fails-with-3"); attempts != 1 {
+		t.Errorf("fails-with-3 attempts: %d, expected 1 (exit code 3 is not retryable)", attempts)
+	}
+}
+
+func TestAbortExecutionTerminatesRunningTasks(t *testing.T) {
+	wf := newTestWorkflow([]workflow.Task{
+		{
+			Name: "long-running",
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "sleep", Args: []interface{}{"30"}},
+			},
+		},
+		{
+			Name:      "after-long-running",
+			DependsOn: []string{"long-running"},
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "echo", Args: []interface{}{"should not run"}},
+			},
+		},
+	})
+	eng, err := engine.NewEngine(wf, 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	time.AfterFunc(200*time.Millisecond, eng.AbortExecution)
+
+	start := time.Now()
+	if err := eng.Run(); err == nil {
+		t.Error("Run should return an error after AbortExecution")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run took %s, the running task was not terminated", elapsed)
+	}
+	for _, name := range []string{"long-running", "after-long-running"} {
+		if status := eng.DAG.GetStatus(name); status != "canceled" {
+			t.Errorf("%s status: %s, expected canceled", name, status)
+		}
+	}
+}
+
+func TestRunContinuePolicy(t *testing.T) {
+	wf := newTestWorkflow([]workflow.Task{
+		{
+			Name: "will-fail",
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "nonexistent_command_xyz"},
+			},
+		},
+		{
+			Name:      "runs-anyway",
+			DependsOn: []string{"will-fail"},
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "echo", Args: []interface{}{"still running"}},
+			},
+		},
+	})
+	wf.OnFailure = "continue"
+	eng, err := engine.NewEngine(wf, 2, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	_ = eng.Run()
+	if status := eng.DAG.GetStatus("runs-anyway"); status != "successful" {
+		t.Errorf("runs-anyway status: %s, expected successful with the continue policy", status)
+	}
+}
+
+func TestRunTaskPolicyOverridesWorkflowPolicy(t *testing.T) {
+	wf := newTestWorkflow([]workflow.Task{
+		{
+			Name:      "will-fail",
+			OnFailure: "abort-all",
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "nonexistent_command_xyz"},
+			},
+		},
+		{
+			Name: "gate",
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "sleep", Args: []interface{}{"0.3"}},
+			},
+		},
+		{
+			Name:      "unrelated",
+			DependsOn: []string{"gate"},
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "echo", Args: []interface{}{"unrelated"}},
+			},
+		},
+	})
+	wf.OnFailure = "continue"
+	eng, err := engine.NewEngine(wf, 2, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	_ = eng.Run()
+	if status := eng.DAG.GetStatus("unrelated"); status != "canceled" {
+		t.Errorf("unrelated status: %s, expected canceled by the task's abort-all policy", status)
+	}
+}
+
+func TestNewEngineUnknownPolicy(t *testing.T) {
+	wf := newTestWorkflow(nil)
+	wf.OnFailure = "ignore-everything"
+	if _, err := engine.NewEngine(wf, 1, false); err == nil {
+		t.Error("NewEngine should reject an unknown on-failure policy")
+	}
+}
+
+func TestRunCleanup(t *testing.T) {
+	dir := t.TempDir()
+	always := filepath.Join(dir, "always")
+	onFailure := filepath.Join(dir, "on-failure")
+	wf := newTestWorkflow([]workflow.Task{
+		{
+			Name: "with-cleanup",
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "echo", Args: []interface{}{"work"}},
+			},
+			Cleanup: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "touch", Args: []interface{}{always}},
+			},
+		},
+		{
+			Name:        "with-failure-cleanup",
+			CleanupWhen: "on-failure",
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "echo", Args: []interface{}{"work"}},
+			},
+			Cleanup: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "touch", Args: []interface{}{onFailure}},
+			},
+		},
+	})
+	eng, err := engine.NewEngine(wf, 2, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	if err := eng.Run(); err != nil {
+		t.Errorf("Run returned error: %v", err)
+	}
+	if _, err := os.Stat(always); err != nil {
+		t.Errorf("Cleanup did not run: %v", err)
+	}
+	if status := eng.DAG.GetCleanupStatus("with-cleanup"); status != "successful" {
+		t.Errorf("with-cleanup cleanup status: %q, expected successful", status)
+	}
+	if _, err := os.Stat(onFailure); err == nil {
+		t.Error("on-failure cleanup should not run after a successful task")
+	}
+	if status := eng.DAG.GetCleanupStatus("with-failure-cleanup"); status != "" {
+		t.Errorf("with-failure-cleanup cleanup status: %q, expected none", status)
+	}
+}
+
+func TestRunCleanupFailureKeepsTaskStatus(t *testing.T) {
+	wf := newTestWorkflow([]workflow.Task{
+		{
+			Name: "task",
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "echo", Args: []interface{}{"work"}},
+			},
+			Cleanup: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "nonexistent_command_xyz"},
+			},
+		},
+	})
+	eng, err := engine.NewEngine(wf, 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	_ = eng.Run()
+	if status := eng.DAG.GetStatus("task"); status != "successful" {
+		t.Errorf("task status: %s, expected successful", status)
+	}
+	if status := eng.DAG.GetCleanupStatus("task"); status != "failed" {
+		t.Errorf("task cleanup status: %q, expected failed", status)
+	}
+}
+
+func TestRunCleanupAfterAbort(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "cleaned")
+	wf := newTestWorkflow([]workflow.Task{
+		{
+			Name:        "long-running",
+			CleanupWhen: "on-cancel",
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "sleep", Args: []interface{}{"30"}},
+			},
+			Cleanup: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "touch", Args: []interface{}{marker}},
+			},
+		},
+	})
+	eng, err := engine.NewEngine(wf, 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	time.AfterFunc(200*time.Millisecond, eng.AbortExecution)
+	_ = eng.Run()
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("Cleanup did not run after abort: %v", err)
+	}
+}
+
+func TestRunCustomAction(t *testing.T) {
+	var got map[string]interface{}
+	runner.Register("test-record", func(params map[string]interface{}) (runner.Runner, error) {
+		got = params
+		return runner.NewExecution("true", map[string]interface{}{"path": "true"}), nil
+	})
+	wf := newTestWorkflow([]workflow.Task{
+		{
+			Name: "custom",
+			Do: workflow.Action{
+				This: "test-record",
+				With: workflow.With{Params: map[string]interface{}{"channel": "#builds"}},
+			},
+		},
+	})
+	eng, err := engine.NewEngine(wf, 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	if err := eng.Run(); err != nil {
+		t.Errorf("Run returned error: %v", err)
+	}
+	if status := eng.DAG.GetStatus("custom"); status != "successful" {
+		t.Errorf("custom status: %s, expected successful", status)
+	}
+	if got["channel"] != "#builds" {
+		t.Errorf("Action params: %v, expected channel=#builds", got)
+	}
+}
+
+func TestRunUnknownActionFailsBeforeStarting(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "started")
+	wf := newTestWorkflow([]workflow.Task{
+		{
+			Name: "first",
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "touch", Args: []interface{}{marker}},
+			},
+		},
+		{
+			Name:      "second",
+			DependsOn: []string{"first"},
+			Do:        workflow.Action{This: "teleport"},
+		},
+	})
+	eng, err := engine.NewEngine(wf, 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	if err := eng.Run(); err == nil {
+		t.Error("Run should fail for an unknown action")
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("No task should start when an action is unknown")
+	}
+}
+
+func TestRunPluginAction(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\ncat > /dev/null\necho '{\"status\": \"success\", \"logs\": [\"done\"]}'\n"
+	if err := os.WriteFile(filepath.Join(dir, runner.PluginPrefix+"test-plugin"), []byte(script), 0755); err != nil {
+		t.Fatalf("Cannot write plugin: %v", err)
+	}
+	wf := newTestWorkflow([]workflow.Task{
+		{
+			Name: "via-plugin",
+			Do:   workflow.Action{This: "test-plugin"},
+		},
+	})
+	wf.Plugins = []string{dir}
+	eng, err := engine.NewEngine(wf, 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	if err := eng.Run(); err != nil {
+		t.Errorf("Run returned error: %v", err)
+	}
+	if status := eng.DAG.GetStatus("via-plugin"); status != "successful" {
+		t.Errorf("via-plugin status: %s, expected successful", status)
+	}
+}
+
+// captureStdout runs fn and returns what it printed to os.Stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Cannot create pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-done
+}
+
+func TestRunPrefixedOutput(t *testing.T) {
+	wf := newTestWorkflow([]workflow.Task{
+		{
+			Name: "two-lines",
+			Do: workflow.Action{
+				This: "process",
+				With: workflow.With{Path: "printf", Args: []interface{}{"first\\nsecond"}},
+			},
+		},
+	})
+	eng, err := engine.NewEngine(wf, 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	output := captureStdout(t, func() { eng.Run() })
+	for _, line := range []string{"[two-lines] first\n", "[two-lines] second\n"} {
+		if !strings.Contains(output, line) {
+			t.Errorf("Output %q does not contain %q", output, line)
+		}
+	}
+}
+
+func TestRunGroupedAndQuietOutput(t *testing.T) {
+	newEngine := func(mode string) *engine.Engine {
+		wf := newTestWorkflow([]workflow.Task{
+			{
+				Name: "greeter",
+				Do: workflow.Action{
+					This: "process",
+					With: workflow.With{Path: "echo", Args: []interface{}{"hello"}},
+				},
+			},
+		})
+		eng, err := engine.NewEngine(wf, 1, false)
+		if err != nil {
+			t.Fatalf("NewEngine error: %v", err)
+		}
+		eng.Output = mode
+		return eng
+	}
+
+	eng := newEngine(engine.OutputGrouped)
+	output := captureStdout(t, func() { eng.Run() })
+	if !strings.Contains(output, "--- Output of greeter ---\nhello\n") {
+		t.Errorf("Grouped output %q does not contain the task block", output)
+	}
+
+	eng = newEngine(engine.OutputQuiet)
+	output = captureStdout(t, func() { eng.Run() })
+	if strings.Contains(output, "hello") {
+		t.Errorf("Quiet output %q should not contain the task output", output)
+	}
+}
+
+func TestRunUnknownOutputMode(t *testing.T) {
+	eng, err := engine.NewEngine(newTestWorkflow(nil), 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	eng.Output = "loud"
+	if err := eng.Run(); err == nil {
+		t.Error("Run should reject an unknown output mode")
+	}
+}
+
+func TestRunWorkflowEnv(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	envFile := filepath.Join(dir, ".env")
+	os.WriteFile(envFile, []byte("GOARCH=386\nCGO_ENABLED=1\n"), 0644)
+	wf := newTestWorkflow([]workflow.Task{
+		{
+			Name: "env",
+			Do: workflow.Action{With: workflow.With{
+				Path:    "sh",
+				Args:    []interface{}{"-c", `echo "$GOARCH $CGO_ENABLED $GOOS" > ` + out},
+				Env:     map[string]interface{}{"GOARCH": "arm64"},
+				EnvFile: envFile,
+			}},
+		},
+	})
+	wf.Env = map[string]interface{}{"GOARCH": "amd64", "CGO_ENABLED": 0, "GOOS": "plan9"}
+	eng, err := engine.NewEngine(wf, 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	if err := eng.Run(); err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	if data, _ := os.ReadFile(out); string(data) != "arm64 1 plan9\n" {
+		t.Errorf("Task environment: %q, expected the task env over the env file over the workflow env", data)
+	}
+}
+
+func TestRunWhenConditionsAndSkipPropagation(t *testing.T) {
+	echo := workflow.Action{With: workflow.With{Path: "echo"}}
+	wf := newTestWorkflow([]workflow.Task{
+		{Name: "skipped", Do: echo, When: `env("GOTASKER_TEST_UNSET") == "1"`},
+		{Name: "ran", Do: echo, When: `!exists("/definitely/not/here")`},
+		{Name: "any", Do: echo, DependsOn: []string{"skipped", "ran"}},
+		{Name: "all", Do: echo, DependsOn: []string{"skipped", "ran"}, SkipPropagation: "all"},
+		{Name: "none", Do: echo, DependsOn: []string{"skipped"}, SkipPropagation: "none"},
+		{Name: "after-any", Do: echo, DependsOn: []string{"any"}, SkipPropagation: "none",
+			When: `tasks.any.status == "skipped" && tasks.ran.status == "successful"`},
+	})
+	eng, err := engine.NewEngine(wf, 2, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	if err := eng.Run(); err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	want := map[string]string{
+		"skipped":   "skipped",
+		"ran":       "successful",
+		"any":       "skipped",
+		"all":       "successful",
+		"none":      "successful",
+		"after-any": "successful",
+	}
+	for name, status := range want {
+		if got := eng.DAG.GetStatus(name); got != status {
+			t.Errorf("%s status: %s, expected %s", name, got, status)
+		}
+	}
+}
+
+func TestRunInvalidWhenConditionFailsTask(t *testing.T) {
+	wf := newTestWorkflow([]workflow.Task{
+		{Name: "bad", Do: workflow.Action{With: workflow.With{Path: "echo"}}, When: `1 < true`},
+	})
+	eng, err := engine.NewEngine(wf, 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	eng.Run()
+	if status := eng.DAG.GetStatus("bad"); status != "failed" {
+		t.Errorf("bad status: %s, expected failed", status)
+	}
+}
+
+func TestRunTriggerRules(t *testing.T) {
+	echo := workflow.Action{With: workflow.With{Path: "echo"}}
+	wf := newTestWorkflow([]workflow.Task{
+		{Name: "build", Do: workflow.Action{With: workflow.With{Path: "nonexistent_command_xyz"}}},
+		{Name: "lint", Do: echo},
+		{Name: "deploy", Do: echo, DependsOn: []string{"build", "lint"}},
+		{Name: "strict", Do: echo, DependsOn: []string{"build"}, Trigger: "all-success"},
+		{Name: "alert", Do: echo, DependsOn: []string{"build", "lint"}, Trigger: "one-failed"},
+		{Name: "lint-alert", Do: echo, DependsOn: []string{"lint"}, Trigger: "one-failed"},
+		{Name: "report", Do: echo, DependsOn: []string{"deploy", "alert"}, Trigger: "all-done"},
+	})
+	wf.OnFailure = "continue"
+	eng, err := engine.NewEngine(wf, 2, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	eng.Run()
+	want := map[string]string{
+		"build":      "failed",
+		"lint":       "successful",
+		"deploy":     "successful", // the continue policy lets default tasks run
+		"strict":     "canceled",
+		"alert":      "successful",
+		"lint-alert": "skipped",
+		"report":     "successful",
+	}
+	for name, status := range want {
+		if got := eng.DAG.GetStatus(name); got != status {
+			t.Errorf("%s status: %s, expected %s", name, got, status)
+		}
+	}
+}
+
+func TestRunTriggerRulesWithAbortPolicy(t *testing.T) {
+	echo := workflow.Action{With: workflow.With{Path: "echo"}}
+	wf := newTestWorkflow([]workflow.Task{
+		{Name: "build", Do: workflow.Action{With: workflow.With{Path: "nonexistent_command_xyz"}}},
+		{Name: "deploy", Do: echo, DependsOn: []string{"build"}},
+		{Name: "notify", Do: echo, DependsOn: []string{"deploy"}, Trigger: "all-done"},
+	})
+	eng, err := engine.NewEngine(wf, 2, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	eng.Run()
+	if status := eng.DAG.GetStatus("deploy"); status != "canceled" {
+		t.Errorf("deploy status: %s, expected canceled", status)
+	}
+	if status := eng.DAG.GetStatus("notify"); status != "successful" {
+		t.Errorf("notify status: %s, expected successful after all its parents finished", status)
+	}
+}
+
+func TestRunResume(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "workflow.yaml")
+	if err := os.WriteFile(path, []byte("tasks: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "runs.log")
+	marker := filepath.Join(dir, "ready")
+	shell := func(script string) workflow.Action {
+		return workflow.Action{This: "shell", With: workflow.With{Params: map[string]interface{}{"script": script}}}
+	}
+	newEngine := func() *engine.Engine {
+		wf := newTestWorkflow([]workflow.Task{
+			{Name: "build", Do: shell("echo build >> " + log + "; echo version=1.2.3 >> $GOTASKER_OUTPUT")},
+			{Name: "test", Do: shell("test -f " + marker + " && echo test >> " + log), DependsOn: []string{"build"}},
+			{Name: "deploy", Do: shell("echo deploy-{{.tasks.build.outputs.version}} >> " + log), DependsOn: []string{"test"}},
+		})
+		eng, err := engine.NewEngine(wf, 2, false)
+		if err != nil {
+			t.Fatalf("NewEngine error: %v", err)
+		}
+		eng.Output = engine.OutputQuiet
+		return eng
+	}
+
+	first := newEngine()
+	store, err := state.New(path, "")
+	if err != nil {
+		t.Fatalf("state.New error: %v", err)
+	}
+	first.Checkpoint = store
+	first.Run()
+	if status := first.DAG.GetStatus("test"); status != "failed" {
+		t.Fatalf("test status: %s, expected failed", status)
+	}
+
+	if err := os.WriteFile(marker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err = state.Load(path, "")
+	if err != nil {
+		t.Fatalf("state.Load error: %v", err)
+	}
+	second := newEngine()
+	second.Checkpoint = store
+	if remaining := second.Resume(store.Checkpoint(), false); remaining != 2 {
+		t.Errorf("Resume left %d tasks to run, expected 2", remaining)
+	}
+	if err := second.Run(); err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	for _, name := range []string{"build", "test", "deploy"} {
+		if status := second.DAG.GetStatus(name); status != "successful" {
+			t.Errorf("%s status: %s, expected successful", name, status)
+		}
+	}
+	data, _ := os.ReadFile(log)
+	if string(data) != "build\ntest\ndeploy-1.2.3\n" {
+		t.Errorf("Runs: %q, expected build once and deploy to see its output", data)
+	}
+	if tasks := store.Checkpoint().Tasks; tasks["deploy"].Status != "successful" {
+		t.Errorf("Checkpoint of deploy: %+v", tasks["deploy"])
+	}
+}
+
+func TestResumeRerunFailed(t *testing.T) {
+	echo := workflow.Action{With: workflow.With{Path: "echo"}}
+	wf := newTestWorkflow([]workflow.Task{
+		{Name: "build", Do: echo},
+		{Name: "test", Do: echo, DependsOn: []string{"build"}},
+		{Name: "lint", Do: echo, DependsOn: []string{"build"}},
+		{Name: "deploy", Do: echo, DependsOn: []string{"test"}},
+	})
+	previous := state.Checkpoint{Tasks: map[string]state.TaskState{
+		"build": {Status: "successful"},
+		"test":  {Status: "failed"},
+		"lint":  {Status: "canceled"},
+	}}
+	eng, err := engine.NewEngine(wf, 2, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	eng.Output = engine.OutputQuiet
+	if remaining := eng.Resume(previous, true); remaining != 2 {
+		t.Errorf("Resume left %d tasks to run, expected 2", remaining)
+	}
+	eng.Run()
+	want := map[string]string{
+		"build":  "successful",
+		"test":   "successful",
+		"lint":   "successful",
+		"deploy": "skipped", // never reached by the previous run
+	}
+	for name, status := range want {
+		if got := eng.DAG.GetStatus(name); got != status {
+			t.Errorf("%s status: %s, expected %s", name, got, status)
+		}
+	}
+}
+
+func TestRunSkipsUpToDateTasks(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "workflow.yaml")
+	if err := os.WriteFile(path, []byte("tasks: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "input.txt")
+	if err := os.WriteFile(input, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "runs.log")
+	output := filepath.Join(dir, "output.txt")
+	run := func(ignoreCache bool) *engine.Engine {
+		t.Helper()
+		wf := newTestWorkflow([]workflow.Task{
+			{
+				Name: "build",
+				Do: workflow.Action{This: "shell", With: workflow.With{Params: map[string]interface{}{
+					"script": "echo build >> " + log + "; cp " + input + " " + output + "; echo hash=$(cat " + input + ") >> $GOTASKER_OUTPUT",
+				}}},
+				Inputs:  []string{input},
+				Outputs: []workflow.Output{{Path: output}},
+			},
+			{
+				Name:      "report",
+				Do:        workflow.Action{This: "shell", With: workflow.With{Params: map[string]interface{}{"script": "echo report-{{.tasks.build.outputs.hash}} >> " + log}}},
+				DependsOn: []string{"build"},
+			},
+		})
+		eng, err := engine.NewEngine(wf, 2, false)
+		if err != nil {
+			t.Fatalf("NewEngine error: %v", err)
+		}
+		eng.Output = engine.OutputQuiet
+		if eng.Cache, err = state.LoadCache(path, ""); err != nil {
+			t.Fatalf("LoadCache error: %v", err)
+		}
+		eng.IgnoreCache = ignoreCache
+		if err := eng.Run(); err != nil {
+			t.Fatalf("Run error: %v", err)
+		}
+		return eng
+	}
+
+	run(false)
+	if eng := run(false); eng.DAG.GetStatus("build") != "up-to-date" || eng.DAG.GetStatus("report") != "successful" {
+		t.Errorf("Second run: build %s, report %s; expected up-to-date and successful",
+			eng.DAG.GetStatus("build"), eng.DAG.GetStatus("report"))
+	}
+	if err := os.WriteFile(input, []byte("v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run(false)
+	if err := os.Remove(output); err != nil {
+		t.Fatal(err)
+	}
+	run(false)
+	run(true)
+
+	data, _ := os.ReadFile(log)
+	expected := "build\nreport-v1\n" + // first run
+		"report-v1\n" + // up to date, with the cached outputs
+		"build\nreport-v2\n" + // input changed
+		"build\nreport-v2\n" + // output removed
+		"build\nreport-v2\n" // forced
+	if string(data) != expected {
+		t.Errorf("Runs:\n%s\nexpected:\n%s", data, expected)
+	}
+}
+
+func TestRunLeavesOutputFilesOutOfOutputs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "workflow.yaml")
+	if err := os.WriteFile(path, []byte("tasks: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "out.txt")
+	wf := newTestWorkflow([]workflow.Task{
+		{
+			Name:    "build",
+			Do:      workflow.Action{This: "shell", With: workflow.With{Params: map[string]interface{}{"script": "echo lots of build log; touch " + output}}},
+			Outputs: []workflow.Output{{Path: output}, {Name: "log"}},
+		},
+	})
+	eng, err := engine.NewEngine(wf, 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	eng.Output = engine.OutputQuiet
+	if eng.Cache, err = state.LoadCache(path, ""); err != nil {
+		t.Fatalf("LoadCache error: %v", err)
+	}
+	if err := eng.Run(); err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+
+	expected := map[string]string{"log": "lots of build log"}
+	if outputs := eng.GetResult("build").Outputs; !reflect.DeepEqual(outputs, expected) {
+		t.Errorf("Outputs: %v, expected %v", outputs, expected)
+	}
+	cache, err := state.LoadCache(path, "")
+	if err != nil {
+		t.Fatalf("LoadCache error: %v", err)
+	}
+	if cached, _ := cache.Get("build"); !reflect.DeepEqual(cached.Outputs, expected) {
+		t.Errorf("Cached outputs: %v, expected %v", cached.Outputs, expected)
+	}
+}
+
+func newSelectionEngine(t *testing.T) *engine.Engine {
+	t.Helper()
+	echo := workflow.Action{With: workflow.With{Path: "echo"}}
+	wf := newTestWorkflow([]workflow.Task{
+		{Name: "deps", Do: echo, Tags: []string{"setup"}},
+		{Name: "build", Do: echo, DependsOn: []string{"deps"}, Tags: []string{"ci"}},
+		{Name: "test", Do: echo, DependsOn: []string{"build"}, Tags: []string{"ci", "slow"}},
+		{Name: "docs", Do: echo, Tags: []string{"docs"}},
+	})
+	eng, err := engine.NewEngine(wf, 2, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	eng.Output = engine.OutputQuiet
+	return eng
+}
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		name      string
+		selection engine.Selection
+		expected  []string
+	}{
+		{"everything", engine.Selection{}, []string{"deps", "build", "test", "docs"}},
+		{"target with dependencies", engine.Selection{Targets: []string{"test"}}, []string{"deps", "build", "test"}},
+		{"target only", engine.Selection{Targets: []string{"test"}, Only: true}, []string{"test"}},
+		{"tags", engine.Selection{Tags: []string{"docs", "slow"}}, []string{"deps", "build", "test", "docs"}},
+		{"tags only", engine.Selection{Tags: []string{"ci"}, Only: true}, []string{"build", "test"}},
+		{"targets and tags", engine.Selection{Targets: []string{"deps"}, Tags: []string{"docs"}}, []string{"deps", "docs"}},
+		{"skip tags", engine.Selection{SkipTags: []string{"slow", "docs"}}, []string{"deps", "build"}},
+		{"skip a dependency", engine.Selection{Targets: []string{"test"}, SkipTags: []string{"setup"}}, []string{"build", "test"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eng := newSelectionEngine(t)
+			if err := eng.Select(tt.selection); err != nil {
+				t.Fatalf("Select error: %v", err)
+			}
+			var names []string
+			for _, task := range eng.TaskCollection {
+				names = append(names, task.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Selected %v, expected %v", names, tt.expected)
+			}
+			if err := eng.Run(); err != nil {
+				t.Fatalf("Run error: %v", err)
+			}
+			for _, name := range tt.expected {
+				if status := eng.DAG.GetStatus(name); status != "successful" {
+					t.Errorf("%s status: %s, expected successful", name, status)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectErrors(t *testing.T) {
+	if err := newSelectionEngine(t).Select(engine.Selection{Targets: []string{"deploy"}}); err == nil || !strings.Contains(err.Error(), "deploy") {
+		t.Errorf("Select of an unknown target returned %v", err)
+	}
+	if err := newSelectionEngine(t).Select(engine.Selection{Tags: []string{"missing"}}); err == nil {
+		t.Error("Select matching no task returned no error")
+	}
+}
@@ -0,0 +1,80 @@
+// Unit tests to ensure the correct function of the "gotasker/src/expr" package.
+package tests
+
+import (
+	"gotasker/src/expr"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExprEval(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "marker")
+	os.WriteFile(file, nil, 0644)
+	t.Setenv("GOTASKER_EXPR_TEST", "yes")
+
+	vars := map[string]interface{}{
+		"target": "linux",
+		"count":  3,
+		"tasks": map[string]interface{}{
+			"build": map[string]interface{}{
+				"status":    "successful",
+				"exit-code": 0,
+				"outputs":   map[string]interface{}{"version": "1.10.0", "changed": "42"},
+			},
+			"lib.test": map[string]interface{}{"status": "failed"},
+		},
+	}
+	tests := map[string]bool{
+		`target == "linux"`:                            true,
+		`target != 'linux'`:                            false,
+		`count > 2 && count <= 3`:                      true,
+		`tasks.build.outputs.changed > 9`:              true,
+		`tasks.build.outputs.changed == 42.0`:          true,
+		`tasks.build.outputs.version == "1.10.0"`:      true,
+		`tasks.build.status == "successful"`:           true,
+		`tasks.build.exit-code == 0`:                   true,
+		`tasks["lib.test"].status == "failed"`:         true,
+		`tasks.deploy.status == null`:                  true,
+		`!tasks.deploy`:                                true,
+		`exists("` + file + `")`:                       true,
+		`exists("` + file + `.missing")`:               false,
+		`env("GOTASKER_EXPR_TEST") == "yes"`:           true,
+		`env("GOTASKER_EXPR_UNSET")`:                   false,
+		`false || (target == "darwin" || count == 3)`:  true,
+		`!(target == "linux") && tasks.nothing.at.all`: false,
+		`"b" > "a"`: true,
+	}
+	for source, want := range tests {
+		got, err := expr.Eval(source, vars)
+		if err != nil {
+			t.Errorf("Eval(%s) returned an error: %v", source, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Eval(%s) = %v, expected %v", source, got, want)
+		}
+	}
+}
+
+func TestExprParseErrors(t *testing.T) {
+	for _, source := range []string{
+		``,
+		`target ==`,
+		`(target == "linux"`,
+		`"unterminated`,
+		`unknown("x")`,
+		`target = "linux"`,
+		`tasks.`,
+	} {
+		if _, err := expr.Parse(source); err == nil {
+			t.Errorf("Parse(%q) should fail", source)
+		}
+	}
+}
+
+func TestExprOrderingMismatchedTypes(t *testing.T) {
+	if _, err := expr.Eval(`true < 3`, nil); err == nil {
+		t.Error("Ordering a boolean and a number should fail")
+	}
+}
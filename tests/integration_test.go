@@ -7,7 +7,9 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func getExamplesDir() string {
@@ -202,3 +204,510 @@ func TestIntegrationNonexistentFile(t *testing.T) {
 		t.Error("Expected error for nonexistent file")
 	}
 }
+
+func TestIntegrationInvalidTaskTimeout(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "workflow.yaml")
+	content := `variables: {}
+tasks:
+  - name: "bad"
+    timeout: "forever"
+    do:
+      this: process
+      with:
+        path: echo
+`
+	os.WriteFile(tmpFile, []byte(content), 0644)
+
+	if _, err := workflow.NewWorkflow(tmpFile); err == nil {
+		t.Error("Expected error for an invalid task timeout")
+	}
+}
+
+func TestIntegrationUnknownPolicy(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "workflow.yaml")
+	content := `variables: {}
+on-failure: abort-some
+tasks:
+  - name: "task"
+    do:
+      this: process
+      with:
+        path: echo
+`
+	os.WriteFile(tmpFile, []byte(content), 0644)
+
+	if _, err := workflow.NewWorkflow(tmpFile); err == nil {
+		t.Error("Expected error for an unknown on-failure policy")
+	}
+}
+
+func TestIntegrationTemplatedEnvAndDir(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "app"), 0755)
+	tmpFile := filepath.Join(dir, "workflow.yaml")
+	content := `variables:
+  root: ` + dir + `
+  target: linux
+env:
+  TARGET: "{{.target}}"
+tasks:
+  - name: "build"
+    do:
+      this: process
+      with:
+        path: sh
+        args: ["-c", "echo $TARGET $MODE > out"]
+        dir: "{{.root}}/app"
+        env:
+          MODE: "release-{{.target}}"
+`
+	os.WriteFile(tmpFile, []byte(content), 0644)
+
+	wf, err := workflow.NewWorkflow(tmpFile)
+	if err != nil {
+		t.Fatalf("NewWorkflow error: %v", err)
+	}
+	eng, err := engine.NewEngine(wf, 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	if err := eng.Run(); err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "app", "out"))
+	if err != nil || string(data) != "linux release-linux\n" {
+		t.Errorf("Task output in dir: %q (%v), expected the templated env", data, err)
+	}
+}
+
+func TestIntegrationInvalidInheritEnv(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "workflow.yaml")
+	content := `variables: {}
+tasks:
+  - name: "task"
+    do:
+      this: process
+      with:
+        path: echo
+        inherit-env: sometimes
+`
+	os.WriteFile(tmpFile, []byte(content), 0644)
+
+	if _, err := workflow.NewWorkflow(tmpFile); err == nil {
+		t.Error("Expected error for an invalid inherit-env")
+	}
+}
+
+func TestIntegrationShellScript(t *testing.T) {
+	dir := t.TempDir()
+	tmpFile := filepath.Join(dir, "workflow.yaml")
+	content := `variables:
+  name: gopher
+tasks:
+  - name: "script"
+    do:
+      this: shell
+      with:
+        dir: ` + dir + `
+        script: |
+          for word in hello {{.name}}; do
+            echo "$word"
+          done > out
+          cat <<EOF >> out
+          done
+          EOF
+`
+	os.WriteFile(tmpFile, []byte(content), 0644)
+
+	wf, err := workflow.NewWorkflow(tmpFile)
+	if err != nil {
+		t.Fatalf("NewWorkflow error: %v", err)
+	}
+	eng, err := engine.NewEngine(wf, 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	if err := eng.Run(); err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "out"))
+	if string(data) != "hello\ngopher\ndone\n" {
+		t.Errorf("Script output: %q, expected the loop and heredoc output", data)
+	}
+}
+
+func TestIntegrationArgsKeepSourceOrder(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"workflow.yaml": `variables: {}
+tasks:
+  - name: "flags"
+    do:
+      this: process
+      with:
+        path: echo
+        flag-style: space
+        args:
+          - zeta: 1
+            alpha: 2
+            mid: 3
+          - positional
+`,
+		"workflow.json": `{"variables": {}, "tasks": [{"name": "flags", "do": {"this": "process", "with": {
+  "path": "echo", "flag-style": "space",
+  "args": [{"zeta": 1, "alpha": 2, "mid": 3}, "positional"]}}}]}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0644)
+
+		wf, err := workflow.NewWorkflow(path)
+		if err != nil {
+			t.Fatalf("%s: NewWorkflow error: %v", name, err)
+		}
+		eng, err := engine.NewEngine(wf, 1, false)
+		if err != nil {
+			t.Fatalf("%s: NewEngine error: %v", name, err)
+		}
+		result, err := eng.ExecuteTask(&wf.Tasks[0])
+		if err != nil {
+			t.Fatalf("%s: ExecuteTask error: %v", name, err)
+		}
+		if result.Stdout != "--zeta 1 --alpha 2 --mid 3 positional\n" {
+			t.Errorf("%s: flags %q, expected the order of the file", name, result.Stdout)
+		}
+	}
+}
+
+func TestIntegrationTaskOutputs(t *testing.T) {
+	dir := t.TempDir()
+	tmpFile := filepath.Join(dir, "workflow.yaml")
+	content := `variables:
+  targets: [linux, darwin]
+tasks:
+  - name: "build"
+    do:
+      this: shell
+      with:
+        script: |
+          echo "version 1.4.2"
+          echo "ARTIFACT=dist/app" >> "$GOTASKER_OUTPUT"
+    outputs:
+      - name: version
+        from: regex
+        pattern: 'version (\S+)'
+  - name: "package-{{.target}}"
+    depends-on: [build]
+    foreach:
+      - variable: targets
+        as: target
+    do:
+      this: shell
+      with:
+        dir: ` + dir + `
+        script: echo "{{.target}} {{.tasks.build.outputs.version}} {{.tasks.build.outputs.ARTIFACT}} {{.tasks.build.status}}" > {{.target}}.txt
+`
+	os.WriteFile(tmpFile, []byte(content), 0644)
+
+	wf, err := workflow.NewWorkflow(tmpFile)
+	if err != nil {
+		t.Fatalf("NewWorkflow error: %v", err)
+	}
+	eng, err := engine.NewEngine(wf, 2, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	if err := eng.Run(); err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	for _, target := range []string{"linux", "darwin"} {
+		data, _ := os.ReadFile(filepath.Join(dir, target+".txt"))
+		if want := target + " 1.4.2 dist/app successful\n"; string(data) != want {
+			t.Errorf("Output of package-%s: %q, expected %q", target, data, want)
+		}
+	}
+}
+
+func TestIntegrationTaskOutputsInTemplateBlocks(t *testing.T) {
+	dir := t.TempDir()
+	tmpFile := filepath.Join(dir, "workflow.yaml")
+	content := `variables:
+  targets: [linux, darwin]
+tasks:
+  - name: "build"
+    do:
+      this: shell
+      with:
+        script: |
+          echo "linux=dist/app" >> "$GOTASKER_OUTPUT"
+          echo "darwin=dist/app.app" >> "$GOTASKER_OUTPUT"
+          echo "signed=yes" >> "$GOTASKER_OUTPUT"
+  - name: "package-{{.target}}"
+    depends-on: [build]
+    foreach:
+      - variable: targets
+        as: target
+    do:
+      this: shell
+      with:
+        dir: ` + dir + `
+        script: echo "{{if .tasks.build.outputs.signed}}signed {{end}}{{index .tasks.build.outputs .target}}" > {{.target}}.txt
+`
+	os.WriteFile(tmpFile, []byte(content), 0644)
+
+	wf, err := workflow.NewWorkflow(tmpFile)
+	if err != nil {
+		t.Fatalf("NewWorkflow error: %v", err)
+	}
+	eng, err := engine.NewEngine(wf, 2, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	if err := eng.Run(); err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	for target, artifact := range map[string]string{"linux": "dist/app", "darwin": "dist/app.app"} {
+		data, _ := os.ReadFile(filepath.Join(dir, target+".txt"))
+		if want := "signed " + artifact + "\n"; string(data) != want {
+			t.Errorf("Output of package-%s: %q, expected %q", target, data, want)
+		}
+	}
+}
+
+func TestIntegrationMissingTaskOutputFailsDependent(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "workflow.yaml")
+	content := `variables: {}
+tasks:
+  - name: "build"
+    do:
+      this: process
+      with:
+        path: "true"
+  - name: "deploy"
+    depends-on: [build]
+    do:
+      this: process
+      with:
+        path: echo
+        args: ["{{.tasks.build.outputs.version}}"]
+`
+	os.WriteFile(tmpFile, []byte(content), 0644)
+
+	wf, err := workflow.NewWorkflow(tmpFile)
+	if err != nil {
+		t.Fatalf("NewWorkflow error: %v", err)
+	}
+	eng, err := engine.NewEngine(wf, 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	eng.Run()
+	if status := eng.DAG.GetStatus("deploy"); status != "failed" {
+		t.Errorf("deploy status: %s, expected failed for a missing output", status)
+	}
+}
+
+func TestIntegrationInvalidWhenCondition(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "workflow.yaml")
+	content := `variables: {}
+tasks:
+  - name: "task"
+    when: 'target =='
+    do:
+      this: process
+      with:
+        path: echo
+`
+	os.WriteFile(tmpFile, []byte(content), 0644)
+
+	wf, err := workflow.NewWorkflow(tmpFile)
+	if err != nil {
+		t.Fatalf("NewWorkflow error: %v", err)
+	}
+	if _, err := engine.NewEngine(wf, 1, false); err == nil || !strings.Contains(err.Error(), "task task: invalid when condition") {
+		t.Errorf("Expected error for an invalid when condition, got %v", err)
+	}
+}
+
+func TestIntegrationSubWorkflow(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "deploy"), 0755)
+	child := `variables:
+  target: dev
+tasks:
+  - name: "migrate"
+    do:
+      this: shell
+      with:
+        script: echo "migrate {{.target}} $REGION" > ` + filepath.Join(dir, "migrate.txt") + `
+  - name: "seed"
+    depends-on: [migrate]
+    do:
+      this: shell
+      with:
+        script: test "{{.target}}" = prod
+`
+	os.WriteFile(filepath.Join(dir, "deploy", "child.yaml"), []byte(child), 0644)
+	parent := `variables:
+  target: prod
+env:
+  REGION: eu
+tasks:
+  - name: "deploy-{{.target}}"
+    do:
+      this: workflow
+      with:
+        file: deploy/child.yaml
+        variables:
+          target: "{{.target}}"
+        threads: 1
+  - name: "deploy-dev"
+    depends-on: [deploy-prod]
+    do:
+      this: workflow
+      with:
+        file: deploy/child.yaml
+`
+	tmpFile := filepath.Join(dir, "workflow.yaml")
+	os.WriteFile(tmpFile, []byte(parent), 0644)
+
+	wf, err := workflow.NewWorkflow(tmpFile)
+	if err != nil {
+		t.Fatalf("NewWorkflow error: %v", err)
+	}
+	eng, err := engine.NewEngine(wf, 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	eng.Output = engine.OutputQuiet
+	if err := eng.Run(); err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+
+	if status := eng.DAG.GetStatus("deploy-prod"); status != "successful" {
+		t.Errorf("deploy-prod status: %s, expected successful", status)
+	}
+	result := eng.GetResult("deploy-prod")
+	if result == nil || len(result.Tasks) != 2 || result.Tasks[0].Name != "migrate" || result.Tasks[1].Status != "successful" {
+		t.Fatalf("deploy-prod result tasks: %+v", result)
+	}
+	// The sub-workflow defaults to its own variables, so its seed task fails.
+	if status := eng.DAG.GetStatus("deploy-dev"); status != "failed" {
+		t.Errorf("deploy-dev status: %s, expected failed", status)
+	}
+	if result := eng.GetResult("deploy-dev"); result == nil || result.Tasks[1].Status != "failed" {
+		t.Errorf("deploy-dev result tasks: %+v", result)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "migrate.txt"))
+	if string(data) != "migrate dev eu\n" {
+		t.Errorf("migrate wrote %q, expected the parent env to reach the sub-workflow", data)
+	}
+}
+
+func TestIntegrationSubWorkflowRunningItself(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "workflow.yaml")
+	content := `variables: {}
+tasks:
+  - name: "loop"
+    do:
+      this: workflow
+      with:
+        file: workflow.yaml
+`
+	os.WriteFile(tmpFile, []byte(content), 0644)
+
+	wf, err := workflow.NewWorkflow(tmpFile)
+	if err != nil {
+		t.Fatalf("NewWorkflow error: %v", err)
+	}
+	eng, err := engine.NewEngine(wf, 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	eng.Output = engine.OutputQuiet
+	eng.Run()
+	if status := eng.DAG.GetStatus("loop"); status != "failed" {
+		t.Errorf("loop status: %s, expected failed", status)
+	}
+}
+
+func TestIntegrationSubWorkflowTimeout(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "child.yaml"), []byte(`variables: {}
+tasks:
+  - name: "slow"
+    do:
+      this: shell
+      with:
+        script: sleep 5
+`), 0644)
+	tmpFile := filepath.Join(dir, "workflow.yaml")
+	os.WriteFile(tmpFile, []byte(`variables: {}
+tasks:
+  - name: "call"
+    timeout: 200ms
+    do:
+      this: workflow
+      with:
+        file: child.yaml
+`), 0644)
+
+	wf, err := workflow.NewWorkflow(tmpFile)
+	if err != nil {
+		t.Fatalf("NewWorkflow error: %v", err)
+	}
+	eng, err := engine.NewEngine(wf, 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	eng.Output = engine.OutputQuiet
+	start := time.Now()
+	eng.Run()
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Run took %s, expected the sub-workflow to be aborted", elapsed)
+	}
+	if status := eng.DAG.GetStatus("call"); status != "timed-out" {
+		t.Errorf("call status: %s, expected timed-out", status)
+	}
+}
+
+func TestIntegrationSubWorkflowForcedAbort(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "child.yaml"), []byte(`variables: {}
+tasks:
+  - name: "stubborn"
+    do:
+      this: shell
+      with:
+        script: trap '' TERM; sleep 30 & wait
+`), 0644)
+	tmpFile := filepath.Join(dir, "workflow.yaml")
+	os.WriteFile(tmpFile, []byte(`variables: {}
+tasks:
+  - name: "call"
+    do:
+      this: workflow
+      with:
+        file: child.yaml
+`), 0644)
+
+	wf, err := workflow.NewWorkflow(tmpFile)
+	if err != nil {
+		t.Fatalf("NewWorkflow error: %v", err)
+	}
+	eng, err := engine.NewEngine(wf, 1, false)
+	if err != nil {
+		t.Fatalf("NewEngine error: %v", err)
+	}
+	eng.Output = engine.OutputQuiet
+	eng.GracePeriod = time.Minute
+	// Like pressing Ctrl-C twice: the second abort kills the processes of
+	// the sub-workflow instead of waiting for the grace period.
+	time.AfterFunc(300*time.Millisecond, eng.AbortExecution)
+	time.AfterFunc(500*time.Millisecond, eng.AbortExecution)
+	start := time.Now()
+	eng.Run()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run took %s, expected the second abort to kill the sub-workflow tasks", elapsed)
+	}
+}
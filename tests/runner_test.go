@@ -1,8 +1,18 @@
 package tests
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"gotasker/src/runner"
+	"gotasker/src/workflow"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewExecution(t *testing.T) {
@@ -101,3 +111,359 @@ func TestExecuteErrorWithComplexArgs(t *testing.T) {
 		t.Error("Execute did not return an error")
 	}
 }
+
+func TestExecuteContextDeadline(t *testing.T) {
+	params := map[string]interface{}{
+		"args": []interface{}{"10"},
+	}
+	e := runner.NewExecution("sleep", params)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := e.ExecuteContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ExecuteContext returned %v, expected a context.DeadlineExceeded error", err)
+	}
+}
+
+func TestExecuteContextKillsAfterGracePeriod(t *testing.T) {
+	params := map[string]interface{}{
+		// The shell ignores SIGTERM, so only SIGKILL can stop it.
+		"args": []interface{}{"-c", "trap '' TERM; sleep 30 & wait"},
+	}
+	e := runner.NewExecution("sh", params)
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = runner.WithKillOptions(ctx, runner.KillOptions{GracePeriod: 100 * time.Millisecond})
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := e.ExecuteContext(ctx)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ExecuteContext took %s, the process group was not killed", elapsed)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ExecuteContext returned %v, expected a context.Canceled error", err)
+	}
+}
+
+func TestExecuteContextForceSkipsGracePeriod(t *testing.T) {
+	params := map[string]interface{}{
+		"args": []interface{}{"-c", "trap '' TERM; sleep 30 & wait"},
+	}
+	e := runner.NewExecution("sh", params)
+	force := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = runner.WithKillOptions(ctx, runner.KillOptions{GracePeriod: time.Minute, Force: force})
+	time.AfterFunc(100*time.Millisecond, cancel)
+	time.AfterFunc(200*time.Millisecond, func() { close(force) })
+
+	start := time.Now()
+	if _, err := e.ExecuteContext(ctx); err == nil {
+		t.Error("ExecuteContext should fail when the process is killed")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ExecuteContext took %s, the forced kill did not skip the grace period", elapsed)
+	}
+}
+
+// greetAction is a Go action used to test the action registry.
+type greetAction struct {
+	name string
+}
+
+func (g *greetAction) ExecuteContext(ctx context.Context) (*runner.Result, error) {
+	return &runner.Result{Stdout: "hello " + g.name}, nil
+}
+
+func TestRegisterAction(t *testing.T) {
+	runner.Register("test-greet", func(params map[string]interface{}) (runner.Runner, error) {
+		name, _ := params["name"].(string)
+		return &greetAction{name: name}, nil
+	})
+	r, err := runner.New("test-greet", map[string]interface{}{"name": "gopher"})
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+	result, err := r.ExecuteContext(context.Background())
+	if err != nil || result.Stdout != "hello gopher" {
+		t.Errorf("ExecuteContext returned (%v, %v), expected \"hello gopher\" on stdout", result, err)
+	}
+}
+
+func TestRegisterActionTwicePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Registering the process action again should panic")
+		}
+	}()
+	runner.Register("process", func(params map[string]interface{}) (runner.Runner, error) {
+		return nil, nil
+	})
+}
+
+func TestNewUnknownAction(t *testing.T) {
+	if _, err := runner.New("does-not-exist", nil); err == nil {
+		t.Error("New should fail for an unknown action")
+	}
+}
+
+// writePlugin creates an action plugin script in dir that stores its request
+// in request.json and replies with the given response.
+func writePlugin(t *testing.T, dir string, action string, response string) {
+	t.Helper()
+	script := "#!/bin/sh\ncat > " + filepath.Join(dir, "request.json") + "\necho '" + response + "'\n"
+	if err := os.WriteFile(filepath.Join(dir, runner.PluginPrefix+action), []byte(script), 0755); err != nil {
+		t.Fatalf("Cannot write plugin: %v", err)
+	}
+}
+
+func TestPluginAction(t *testing.T) {
+	dir := t.TempDir()
+	writePlugin(t, dir, "deploy", `{"status": "success", "logs": ["deployed"], "outputs": {"id": "42"}}`)
+
+	factory, err := runner.Resolve("deploy", []string{dir})
+	if err != nil {
+		t.Fatalf("Resolve returned an error: %v", err)
+	}
+	r, err := factory(map[string]interface{}{"env": "staging"})
+	if err != nil {
+		t.Fatalf("Factory returned an error: %v", err)
+	}
+	result, err := r.ExecuteContext(context.Background())
+	if err != nil {
+		t.Fatalf("ExecuteContext returned an error: %v", err)
+	}
+	if result.Stdout != "deployed\n" {
+		t.Errorf("Plugin stdout: %q, expected the logs", result.Stdout)
+	}
+	if id := result.Outputs["id"]; id != "42" {
+		t.Errorf("Plugin output id: %q, expected 42", id)
+	}
+
+	var request runner.PluginRequest
+	data, _ := os.ReadFile(filepath.Join(dir, "request.json"))
+	if err := json.Unmarshal(data, &request); err != nil {
+		t.Fatalf("Plugin received an invalid request %q: %v", data, err)
+	}
+	if request.Action != "deploy" || request.Params["env"] != "staging" {
+		t.Errorf("Plugin received %+v, expected the action and its params", request)
+	}
+}
+
+func TestPluginActionFailure(t *testing.T) {
+	dir := t.TempDir()
+	writePlugin(t, dir, "broken", `{"status": "failure", "error": "no credentials"}`)
+
+	factory, err := runner.Resolve("broken", []string{dir})
+	if err != nil {
+		t.Fatalf("Resolve returned an error: %v", err)
+	}
+	r, _ := factory(nil)
+	if _, err := r.ExecuteContext(context.Background()); err == nil {
+		t.Error("ExecuteContext should fail when the plugin reports a failure")
+	}
+}
+
+func TestResolveUnknownAction(t *testing.T) {
+	if _, err := runner.Resolve("does-not-exist", []string{t.TempDir()}); err == nil {
+		t.Error("Resolve should fail when no plugin provides the action")
+	}
+}
+
+func TestExecuteContextResult(t *testing.T) {
+	e := runner.NewExecution("sh", map[string]interface{}{
+		"args": []interface{}{"-c", "echo out; echo err >&2; exit 3"},
+	})
+	result, err := e.Execute()
+	if err == nil {
+		t.Fatal("Execute should fail when the process exits with a non-zero status")
+	}
+	if result.Stdout != "out\n" || result.Stderr != "err\n" {
+		t.Errorf("Result stdout %q and stderr %q, expected them separated", result.Stdout, result.Stderr)
+	}
+	if result.ExitCode != 3 {
+		t.Errorf("Result exit code: %d, expected 3", result.ExitCode)
+	}
+	if result.StartTime.IsZero() || result.EndTime.Before(result.StartTime) {
+		t.Errorf("Result times %v - %v are not set", result.StartTime, result.EndTime)
+	}
+}
+
+func TestExecuteContextResultSignal(t *testing.T) {
+	e := runner.NewExecution("sh", map[string]interface{}{
+		"args": []interface{}{"-c", "kill -9 $$"},
+	})
+	result, err := e.Execute()
+	if err == nil {
+		t.Fatal("Execute should fail when the process is killed")
+	}
+	if result.ExitCode != -1 || result.Signal != "killed" {
+		t.Errorf("Result exit code %d and signal %q, expected -1 and killed", result.ExitCode, result.Signal)
+	}
+}
+
+func TestExecuteContextStreamsOutput(t *testing.T) {
+	params := map[string]interface{}{
+		"args": []interface{}{"-c", "echo out; echo err >&2"},
+	}
+	e := runner.NewExecution("sh", params)
+	var stdout, stderr bytes.Buffer
+	ctx := runner.WithOutput(context.Background(), &stdout, &stderr)
+	if _, err := e.ExecuteContext(ctx); err != nil {
+		t.Fatalf("ExecuteContext returned an error: %v", err)
+	}
+	if stdout.String() != "out\n" || stderr.String() != "err\n" {
+		t.Errorf("Streamed stdout %q and stderr %q, expected \"out\\n\" and \"err\\n\"", stdout.String(), stderr.String())
+	}
+}
+
+func TestExecuteContextEnvAndDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, ".env"), []byte("# toolchain\nexport CC=\"clang\"\nLEVEL=1\n"), 0644)
+	t.Setenv("GOTASKER_TEST_KEPT", "kept")
+	t.Setenv("GOTASKER_TEST_DROPPED", "dropped")
+
+	e := runner.NewExecution("sh", map[string]interface{}{
+		"args":        []interface{}{"-c", `echo "$(pwd -P) $CC $LEVEL $GOTASKER_TEST_KEPT $GOTASKER_TEST_DROPPED"`},
+		"dir":         dir,
+		"env-file":    ".env",
+		"env":         map[string]interface{}{"LEVEL": 2},
+		"inherit-env": []interface{}{"PATH", "GOTASKER_TEST_KEPT"},
+	})
+	result, err := e.Execute()
+	if err != nil {
+		t.Fatalf("Execute returned an error: %v", err)
+	}
+	resolved, _ := filepath.EvalSymlinks(dir)
+	if want := resolved + " clang 2 kept \n"; result.Stdout != want {
+		t.Errorf("Execute output: %q, expected %q", result.Stdout, want)
+	}
+}
+
+func TestExecuteContextInvalidInheritEnv(t *testing.T) {
+	e := runner.NewExecution("true", map[string]interface{}{"inherit-env": "yes"})
+	if _, err := e.Execute(); err == nil {
+		t.Error("Execute should fail for an invalid inherit-env")
+	}
+}
+
+func TestShellAction(t *testing.T) {
+	r, err := runner.New("shell", map[string]interface{}{
+		"script": "printf 'b\\na\\n' | sort | tr -d '\\n' && echo \" $1\"\n",
+		"args":   []interface{}{"first"},
+	})
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+	result, err := r.ExecuteContext(context.Background())
+	if err != nil {
+		t.Fatalf("ExecuteContext returned an error: %v", err)
+	}
+	if result.Stdout != "ab first\n" {
+		t.Errorf("Shell output: %q, expected \"ab first\\n\"", result.Stdout)
+	}
+}
+
+func TestShellActionStrictMode(t *testing.T) {
+	script := "echo start\nfalse\necho unreachable\n"
+	r, _ := runner.New("shell", map[string]interface{}{"script": script})
+	result, err := r.ExecuteContext(context.Background())
+	if err == nil || strings.Contains(result.Stdout, "unreachable") {
+		t.Errorf("Strict script returned (%q, %v), expected it to stop at the failing command", result.Stdout, err)
+	}
+
+	r, _ = runner.New("shell", map[string]interface{}{"script": script, "strict": false})
+	result, err = r.ExecuteContext(context.Background())
+	if err != nil || !strings.Contains(result.Stdout, "unreachable") {
+		t.Errorf("Non-strict script returned (%q, %v), expected it to run to the end", result.Stdout, err)
+	}
+}
+
+func TestShellActionLineNumbers(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not available")
+	}
+	r, _ := runner.New("shell", map[string]interface{}{
+		"shell":  "bash",
+		"script": "echo one\necho two\necho \"$UNDEFINED_GOTASKER_VARIABLE\"\n",
+	})
+	result, err := r.ExecuteContext(context.Background())
+	if err == nil {
+		t.Fatal("ExecuteContext should fail on an unset variable in strict mode")
+	}
+	if !strings.Contains(result.Stderr, "line 3") {
+		t.Errorf("Shell stderr: %q, expected the error on line 3", result.Stderr)
+	}
+}
+
+func TestShellActionRequiresScript(t *testing.T) {
+	if _, err := runner.New("shell", map[string]interface{}{}); err == nil {
+		t.Error("New should fail without a script")
+	}
+}
+
+func TestExecuteFlagStyles(t *testing.T) {
+	args := []interface{}{
+		map[string]interface{}{"output": "out.txt"},
+		map[string]interface{}{"verbose": true},
+		map[string]interface{}{"quiet": false},
+		map[string]interface{}{"tag": []interface{}{"a", "b"}},
+	}
+	tests := []struct {
+		style, boolFlags, want string
+	}{
+		{"", "", "--output=out.txt --verbose=true --quiet=false --tag=a --tag=b\n"},
+		{workflow.FlagStyleEquals, workflow.BoolFlagsValue, "--output=out.txt --verbose=true --quiet=false --tag=a --tag=b\n"},
+		{workflow.FlagStyleSpace, "", "--output out.txt --verbose true --quiet false --tag a --tag b\n"},
+		{workflow.FlagStyleShort, "", "-output out.txt -verbose true -quiet false -tag a -tag b\n"},
+		{"", workflow.BoolFlagsBare, "--output=out.txt --verbose --tag=a --tag=b\n"},
+		{workflow.FlagStyleSpace, workflow.BoolFlagsBare, "--output out.txt --verbose --tag a --tag b\n"},
+		{workflow.FlagStyleShort, workflow.BoolFlagsBare, "-output out.txt -verbose -tag a -tag b\n"},
+	}
+	for _, test := range tests {
+		e := runner.NewExecution("echo", map[string]interface{}{"args": args, "flag-style": test.style, "bool-flags": test.boolFlags})
+		result, err := e.Execute()
+		if err != nil {
+			t.Fatalf("Execute with flag-style %q and bool-flags %q returned an error: %v", test.style, test.boolFlags, err)
+		}
+		if result.Stdout != test.want {
+			t.Errorf("Execute with flag-style %q and bool-flags %q: %q, expected %q", test.style, test.boolFlags, result.Stdout, test.want)
+		}
+	}
+}
+
+func TestExecuteKeepsFalseFlagsByDefault(t *testing.T) {
+	e := runner.NewExecution("echo", map[string]interface{}{
+		"args": []interface{}{map[string]interface{}{"cache": false}},
+	})
+	result, err := e.Execute()
+	if err != nil {
+		t.Fatalf("Execute returned an error: %v", err)
+	}
+	if result.Stdout != "--cache=false\n" {
+		t.Errorf("Execute output: %q, expected --cache=false", result.Stdout)
+	}
+}
+
+func TestExecuteMultiKeyArgMapIsSorted(t *testing.T) {
+	e := runner.NewExecution("echo", map[string]interface{}{
+		"args": []interface{}{map[string]interface{}{"c": 3, "a": 1, "b": 2}},
+	})
+	result, err := e.Execute()
+	if err != nil {
+		t.Fatalf("Execute returned an error: %v", err)
+	}
+	if result.Stdout != "--a=1 --b=2 --c=3\n" {
+		t.Errorf("Execute output: %q, expected the flags in key order", result.Stdout)
+	}
+}
+
+func TestExecuteUnknownFlagStyle(t *testing.T) {
+	e := runner.NewExecution("echo", map[string]interface{}{"flag-style": "dashes"})
+	if _, err := e.Execute(); err == nil {
+		t.Error("Execute should fail for an unknown flag-style")
+	}
+	e = runner.NewExecution("echo", map[string]interface{}{"bool-flags": "naked"})
+	if _, err := e.Execute(); err == nil {
+		t.Error("Execute should fail for an unknown bool-flags")
+	}
+}
@@ -0,0 +1,156 @@
+// Unit tests to ensure the correct function of the "gotasker/src/state" package.
+package tests
+
+import (
+	"errors"
+	"gotasker/src/state"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestWorkflowFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "workflow.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("error writing workflow: %v", err)
+	}
+	return path
+}
+
+func TestStatePath(t *testing.T) {
+	path := writeTestWorkflowFile(t, "tasks: []\n")
+	checkpoint, err := state.Path(path, "")
+	if err != nil {
+		t.Fatalf("Path returned an error: %v", err)
+	}
+	if filepath.Dir(checkpoint) != filepath.Join(filepath.Dir(path), state.Dir) {
+		t.Errorf("Checkpoint %s is not in the %s directory next to the workflow", checkpoint, state.Dir)
+	}
+	other, _ := state.Path(filepath.Join(filepath.Dir(path), "other.yaml"), "")
+	if other == checkpoint {
+		t.Error("Two workflows of the same directory share a checkpoint")
+	}
+}
+
+func TestStatePathInStateDir(t *testing.T) {
+	stateDir := t.TempDir()
+	path := writeTestWorkflowFile(t, "tasks: []\n")
+	other := writeTestWorkflowFile(t, "tasks: []\n")
+	checkpoint, err := state.Path(path, stateDir)
+	if err != nil {
+		t.Fatalf("Path returned an error: %v", err)
+	}
+	if filepath.Dir(checkpoint) != stateDir {
+		t.Errorf("Checkpoint %s is not in the state directory %s", checkpoint, stateDir)
+	}
+	if otherCheckpoint, _ := state.Path(other, stateDir); otherCheckpoint == checkpoint {
+		t.Error("Two workflows sharing a state directory share a checkpoint")
+	}
+
+	store, err := state.New(path, stateDir)
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+	if err := store.Record("build", "successful", nil); err != nil {
+		t.Fatalf("Record returned an error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(path), state.Dir)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("The %s directory was created next to the workflow: %v", state.Dir, err)
+	}
+	loaded, err := state.Load(path, stateDir)
+	if err != nil {
+		t.Fatalf("Load returned an error: %v", err)
+	}
+	if loaded.Checkpoint().Tasks["build"].Status != "successful" {
+		t.Errorf("build state: %+v", loaded.Checkpoint().Tasks["build"])
+	}
+}
+
+func TestStateRecordAndLoad(t *testing.T) {
+	path := writeTestWorkflowFile(t, "tasks: []\n")
+	if _, err := state.Load(path, ""); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Load without a previous run returned %v, expected os.ErrNotExist", err)
+	}
+
+	store, err := state.New(path, "")
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+	if err := store.Record("build", "successful", map[string]string{"version": "1.2.3"}); err != nil {
+		t.Fatalf("Record returned an error: %v", err)
+	}
+	if err := store.Record("test", "failed", nil); err != nil {
+		t.Fatalf("Record returned an error: %v", err)
+	}
+
+	loaded, err := state.Load(path, "")
+	if err != nil {
+		t.Fatalf("Load returned an error: %v", err)
+	}
+	tasks := loaded.Checkpoint().Tasks
+	if tasks["build"].Status != "successful" || tasks["build"].Outputs["version"] != "1.2.3" {
+		t.Errorf("build state: %+v", tasks["build"])
+	}
+	if tasks["test"].Status != "failed" {
+		t.Errorf("test state: %+v", tasks["test"])
+	}
+	if loaded.Changed() {
+		t.Error("Changed reported an unchanged workflow as changed")
+	}
+	if err := os.WriteFile(path, []byte("tasks: [{name: x}]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Changed() {
+		t.Error("Changed did not report the edited workflow")
+	}
+	if err := loaded.UpdateHash(); err != nil {
+		t.Fatalf("UpdateHash returned an error: %v", err)
+	}
+	reloaded, err := state.Load(path, "")
+	if err != nil {
+		t.Fatalf("Load returned an error: %v", err)
+	}
+	if reloaded.Changed() {
+		t.Error("Changed still reported the workflow as changed after UpdateHash")
+	}
+	if len(reloaded.Checkpoint().Tasks) != 2 {
+		t.Errorf("UpdateHash lost the recorded tasks: %+v", reloaded.Checkpoint().Tasks)
+	}
+}
+
+func TestCache(t *testing.T) {
+	path := writeTestWorkflowFile(t, "tasks: []\n")
+	cache, err := state.LoadCache(path, "")
+	if err != nil {
+		t.Fatalf("LoadCache without a previous run returned an error: %v", err)
+	}
+	if _, ok := cache.Get("build"); ok {
+		t.Error("A new cache should be empty")
+	}
+	if err := cache.Put("build", "abc", map[string]string{"version": "1.0"}); err != nil {
+		t.Fatalf("Put returned an error: %v", err)
+	}
+	if err := cache.Put("test", "def", nil); err != nil {
+		t.Fatalf("Put returned an error: %v", err)
+	}
+	if err := cache.Delete("test"); err != nil {
+		t.Fatalf("Delete returned an error: %v", err)
+	}
+
+	loaded, err := state.LoadCache(path, "")
+	if err != nil {
+		t.Fatalf("LoadCache returned an error: %v", err)
+	}
+	if fingerprint, ok := loaded.Get("build"); !ok || fingerprint.Hash != "abc" || fingerprint.Outputs["version"] != "1.0" {
+		t.Errorf("build fingerprint: %+v", fingerprint)
+	}
+	if _, ok := loaded.Get("test"); ok {
+		t.Error("A deleted fingerprint was loaded")
+	}
+	checkpoint, _ := state.Path(path, "")
+	cachePath, _ := state.CachePath(path, "")
+	if cachePath == checkpoint {
+		t.Error("The cache and the checkpoint share a file")
+	}
+}
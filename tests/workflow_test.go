@@ -1,9 +1,15 @@
 package tests
 
 import (
+	"encoding/json"
+	"gotasker/src/engine"
 	"gotasker/src/workflow"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 // --- Tests for ReplacePlaceholders ---
@@ -144,7 +150,10 @@ func TestExpandTask(t *testing.T) {
 			"key2": "var2-value2",
 		},
 	}
-	actual := workflow.ExpandTask(input, variables)
+	actual, err := workflow.ExpandTask(input, variables)
+	if err != nil {
+		t.Fatalf("ExpandTask failed: %v", err)
+	}
 	if !reflect.DeepEqual(expected, actual) {
 		t.Errorf("Expected %v, but got %v", expected, actual)
 	}
@@ -199,7 +208,10 @@ func TestExpandTaskWithNestedForeach(t *testing.T) {
 			"key2": "var2-value2",
 		},
 	}
-	actual := workflow.ExpandTask(input, variables)
+	actual, err := workflow.ExpandTask(input, variables)
+	if err != nil {
+		t.Fatalf("ExpandTask failed: %v", err)
+	}
 	if !reflect.DeepEqual(expected, actual) {
 		t.Errorf("Expected %v, but got %v", expected, actual)
 	}
@@ -254,7 +266,10 @@ func TestExpandTaskWithNestedForeachAndVariables(t *testing.T) {
 			"key2": "var2-value2",
 		},
 	}
-	actual := workflow.ExpandTask(input, variables)
+	actual, err := workflow.ExpandTask(input, variables)
+	if err != nil {
+		t.Fatalf("ExpandTask failed: %v", err)
+	}
 	if !reflect.DeepEqual(expected, actual) {
 		t.Errorf("Expected %v, but got %v", expected, actual)
 	}
@@ -640,3 +655,888 @@ func TestProcessWorkflowWithNestedForeachAndVariablesAndMap(t *testing.T) {
 		t.Errorf("Expected %v, but got %v", expected, actual)
 	}
 }
+
+// --- Tests for RetryPolicy ---
+
+func TestRetryPolicyExponentialBackoff(t *testing.T) {
+	task := workflow.Task{Retries: 3, RetryDelay: "1s", Backoff: "exponential"}
+	policy, err := task.RetryPolicy()
+	if err != nil {
+		t.Fatalf("RetryPolicy returned error: %v", err)
+	}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+	for i, want := range expected {
+		if got := policy.Backoff(i + 1); got != want {
+			t.Errorf("Backoff(%d) = %s, expected %s", i+1, got, want)
+		}
+	}
+}
+
+func TestRetryPolicyBackoffIsCapped(t *testing.T) {
+	cases := map[string]time.Duration{
+		"1s":   workflow.MaxBackoff,
+		"100h": 100 * time.Hour,
+	}
+	for delay, want := range cases {
+		task := workflow.Task{Retries: 100, RetryDelay: delay, Backoff: "exponential"}
+		policy, err := task.RetryPolicy()
+		if err != nil {
+			t.Fatalf("RetryPolicy returned error: %v", err)
+		}
+		for _, attempt := range []int{17, 64, 100} {
+			if got := policy.Backoff(attempt); got != want {
+				t.Errorf("Backoff(%d) with a %s delay = %s, expected %s", attempt, delay, got, want)
+			}
+		}
+	}
+}
+
+func TestRetryPolicyJitter(t *testing.T) {
+	task := workflow.Task{Retries: 1, RetryDelay: "1s", RetryJitter: true}
+	policy, err := task.RetryPolicy()
+	if err != nil {
+		t.Fatalf("RetryPolicy returned error: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if got := policy.Backoff(1); got < 500*time.Millisecond || got > time.Second {
+			t.Fatalf("Backoff with jitter = %s, expected between 500ms and 1s", got)
+		}
+	}
+}
+
+func TestRetryPolicyShouldRetry(t *testing.T) {
+	task := workflow.Task{Retries: 1, RetryOn: []interface{}{float64(75), "connection refused"}}
+	policy, err := task.RetryPolicy()
+	if err != nil {
+		t.Fatalf("RetryPolicy returned error: %v", err)
+	}
+	if !policy.ShouldRetry(75, "") {
+		t.Error("Exit code 75 should be retried")
+	}
+	if !policy.ShouldRetry(1, "dial tcp: connection refused") {
+		t.Error("Output matching a retry-on pattern should be retried")
+	}
+	if policy.ShouldRetry(1, "syntax error") {
+		t.Error("Unmatched failures should not be retried")
+	}
+}
+
+func TestRetryPolicyInvalid(t *testing.T) {
+	invalid := []workflow.Task{
+		{Retries: -1},
+		{RetryDelay: "later"},
+		{Backoff: "linear"},
+		{RetryOn: []interface{}{"("}},
+	}
+	for _, task := range invalid {
+		if _, err := task.RetryPolicy(); err == nil {
+			t.Errorf("RetryPolicy should reject %+v", task)
+		}
+	}
+}
+
+// --- Tests for With ---
+
+func TestWithKeepsUnknownParams(t *testing.T) {
+	var with workflow.With
+	data := []byte(`{"path": "echo", "args": ["hi"], "channel": "#builds"}`)
+	if err := json.Unmarshal(data, &with); err != nil {
+		t.Fatalf("Unmarshal returned error: %v", err)
+	}
+	if with.Path != "echo" || !reflect.DeepEqual(with.Params, map[string]interface{}{"channel": "#builds"}) {
+		t.Errorf("Unexpected With, expected only the unknown params in Params: %+v", with)
+	}
+
+	encoded, err := json.Marshal(with)
+	if err != nil {
+		t.Fatalf("Marshal returned error: %v", err)
+	}
+	var roundTrip map[string]interface{}
+	json.Unmarshal(encoded, &roundTrip)
+	if roundTrip["channel"] != "#builds" || roundTrip["path"] != "echo" {
+		t.Errorf("Marshal lost params: %s", encoded)
+	}
+}
+
+func TestOutputExtract(t *testing.T) {
+	stdout := "building...\n{\"artifact\": {\"id\": 42, \"tags\": [\"latest\", \"v1.2.0\"]}}\n"
+	file := map[string]string{"PATH_OUT": "dist/app"}
+	tests := []struct {
+		output workflow.Output
+		want   string
+	}{
+		{workflow.Output{Name: "all"}, strings.TrimSpace(stdout)},
+		{workflow.Output{Name: "tag", From: "regex", Pattern: `"(v[0-9.]+)"`}, "v1.2.0"},
+		{workflow.Output{Name: "step", From: "regex", Pattern: `build\w+`}, "building"},
+		{workflow.Output{Name: "path", From: "file", Key: "PATH_OUT"}, "dist/app"},
+	}
+	for _, tt := range tests {
+		got, err := tt.output.Extract(stdout, file)
+		if err != nil || got != tt.want {
+			t.Errorf("Extract(%+v) = (%q, %v), expected %q", tt.output, got, err, tt.want)
+		}
+	}
+
+	document := `{"artifact": {"id": 42, "tags": ["latest", "v1.2.0"], "meta": {"a": true}}}`
+	queries := map[string]string{
+		".artifact.id":      "42",
+		".artifact.tags[1]": "v1.2.0",
+		"artifact.meta":     `{"a":true}`,
+	}
+	for query, want := range queries {
+		got, err := workflow.Output{Name: "q", From: "json", Query: query}.Extract(document, nil)
+		if err != nil || got != want {
+			t.Errorf("Extract with query %s = (%q, %v), expected %q", query, got, err, want)
+		}
+	}
+}
+
+func TestOutputExtractErrors(t *testing.T) {
+	outputs := []workflow.Output{
+		{Name: "missing-match", From: "regex", Pattern: "version (\\d+)"},
+		{Name: "not-json", From: "json", Query: ".id"},
+		{Name: "missing-key", From: "file"},
+	}
+	for _, output := range outputs {
+		if _, err := output.Extract("no version here", map[string]string{}); err == nil {
+			t.Errorf("Extract(%+v) should fail", output)
+		}
+	}
+}
+
+func TestNewWorkflowRejectsInvalidOutputs(t *testing.T) {
+	cases := map[string]string{
+		"pattern only applies to the regex source, not stdout": "{name: v, pattern: 'v(\\d+)'}",
+		"query only applies to the json source, not regex":     "{name: v, from: regex, pattern: x, query: .id}",
+		"key only applies to the file source, not json":        "{name: v, from: json, query: .id, key: V}",
+		"the regex source requires a pattern":                  "{name: v, from: regex}",
+		"either a file path or a named value":                  "{path: out.txt, key: V}",
+	}
+	for expected, output := range cases {
+		path := filepath.Join(t.TempDir(), "workflow.yaml")
+		os.WriteFile(path, []byte("tasks:\n  - name: a\n    do: {with: {path: echo}}\n    outputs: ["+output+"]\n"), 0644)
+		if _, err := workflow.NewWorkflow(path); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected an error containing %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestReplacePlaceholdersDefersTaskReferences(t *testing.T) {
+	input := map[string]interface{}{
+		"args": []interface{}{"{{.name}}-{{.tasks.build.outputs.version}}", `{{index .tasks "build" "status"}}`},
+	}
+	result := workflow.ReplacePlaceholders(input, map[string]interface{}{"name": "app"}).(map[string]interface{})
+	args := result["args"].([]interface{})
+	if args[0] != "{{.name}}-{{.tasks.build.outputs.version}}" || args[1] != `{{index .tasks "build" "status"}}` {
+		t.Errorf("ReplacePlaceholders returned %v, expected the templates reading .tasks to be kept whole", args)
+	}
+}
+
+func TestRenderTaskKeepsUnsetCleanup(t *testing.T) {
+	task := workflow.Task{
+		Name: "b",
+		Do: workflow.Action{This: "shell", With: workflow.With{
+			Params: map[string]interface{}{"script": "echo {{.tasks.a.status}}"},
+		}},
+	}
+	rendered, err := workflow.RenderTask(task, map[string]interface{}{
+		"tasks": map[string]interface{}{"a": map[string]interface{}{"status": "successful"}},
+	})
+	if err != nil {
+		t.Fatalf("RenderTask returned an error: %v", err)
+	}
+	if rendered.Do.With.Params["script"] != "echo successful" {
+		t.Errorf("Expected the script to be rendered, got %v", rendered.Do.With.Params["script"])
+	}
+	if rendered.Cleanup.IsSet() {
+		t.Errorf("Expected the cleanup to stay unset, got %+v", rendered.Cleanup)
+	}
+	if len(rendered.Do.With.Params) != 1 || rendered.Do.With.Path != "" || rendered.Do.With.Args != nil {
+		t.Errorf("Expected no zero-valued params to be added, got %+v", rendered.Do.With)
+	}
+}
+
+func TestExpandGlobs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"main.go", "README.md", "pkg/a.go", "pkg/deep/b.go", "assets/logo.png"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := workflow.ExpandGlobs(dir, []string{"**/*.go", "assets", "missing/*.txt", "main.go"})
+	if err != nil {
+		t.Fatalf("ExpandGlobs returned an error: %v", err)
+	}
+	var rel []string
+	for _, file := range files {
+		r, _ := filepath.Rel(dir, file)
+		rel = append(rel, filepath.ToSlash(r))
+	}
+	expected := []string{"assets/logo.png", "main.go", "pkg/a.go", "pkg/deep/b.go"}
+	if !reflect.DeepEqual(rel, expected) {
+		t.Errorf("ExpandGlobs returned %v, expected %v", rel, expected)
+	}
+	if err := workflow.ValidateGlob("src/[a-"); err == nil {
+		t.Error("ValidateGlob accepted a malformed pattern")
+	}
+}
+
+func TestOutputFilesAndValues(t *testing.T) {
+	var task workflow.Task
+	data := `{"name": "build", "inputs": ["src/**"], "outputs": ["bin/app", {"name": "version", "from": "file"}]}`
+	if err := json.Unmarshal([]byte(data), &task); err != nil {
+		t.Fatalf("Unmarshal returned an error: %v", err)
+	}
+	if paths := task.OutputPaths(); !reflect.DeepEqual(paths, []string{"bin/app"}) {
+		t.Errorf("OutputPaths returned %v, expected [bin/app]", paths)
+	}
+	if task.Outputs[1].Name != "version" || task.Outputs[1].From != "file" {
+		t.Errorf("Named output decoded as %+v", task.Outputs[1])
+	}
+	if !task.IsIncremental() {
+		t.Error("A task with inputs should be incremental")
+	}
+	if (&workflow.Task{Name: "plain"}).IsIncremental() {
+		t.Error("A task without inputs or output files should not be incremental")
+	}
+}
+
+func TestNewWorkflowWithOptionsOverridesVariables(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "workflow.yaml")
+	content := `variables:
+  target: dev
+  names: [a, b]
+tasks:
+  - name: "deploy-{{.target}}-{{.name}}"
+    foreach:
+      - variable: names
+        as: name
+    do:
+      this: process
+      with:
+        path: echo
+`
+	os.WriteFile(tmpFile, []byte(content), 0644)
+	wf, err := workflow.NewWorkflowWithOptions(tmpFile, workflow.Options{
+		Variables: map[string]interface{}{"target": "prod", "names": []interface{}{"c"}},
+	})
+	if err != nil {
+		t.Fatalf("NewWorkflowWithOptions error: %v", err)
+	}
+	if len(wf.Tasks) != 1 || wf.Tasks[0].Name != "deploy-prod-c" {
+		t.Errorf("Tasks: %+v, expected deploy-prod-c", wf.Tasks)
+	}
+	if !filepath.IsAbs(wf.File) || filepath.Base(wf.File) != "workflow.yaml" {
+		t.Errorf("File: %q, expected the absolute path of the workflow", wf.File)
+	}
+}
+
+func TestProcessWorkflowForeachErrors(t *testing.T) {
+	cases := map[string]map[string]interface{}{
+		"undefined variable": {"names": []interface{}{"a"}},
+		"not a list":         {"missing": "a"},
+	}
+	for name, variables := range cases {
+		input := map[string]interface{}{
+			"variables": variables,
+			"tasks": []interface{}{
+				map[string]interface{}{
+					"name":    "task-{{.item}}",
+					"foreach": []interface{}{map[string]interface{}{"variable": "missing", "as": "item"}},
+				},
+			},
+		}
+		if _, err := workflow.ProcessWorkflow(input); err == nil || !strings.Contains(err.Error(), `"missing"`) {
+			t.Errorf("%s: expected an error naming the foreach variable, got %v", name, err)
+		}
+	}
+}
+
+func TestNewWorkflowRejectsUnknownDependencies(t *testing.T) {
+	cases := map[string]string{
+		"duplicate task name": "tasks:\n  - name: a\n  - name: a\n",
+		"unknown task":        "tasks:\n  - name: a\n    depends-on: [b]\n",
+	}
+	for expected, content := range cases {
+		path := filepath.Join(t.TempDir(), "workflow.yaml")
+		os.WriteFile(path, []byte(content), 0644)
+		if _, err := workflow.NewWorkflow(path); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected an error about %s, got %v", expected, err)
+		}
+	}
+}
+
+func TestNewWorkflowTaskReferencesNeedDependencies(t *testing.T) {
+	const tasks = `tasks:
+  - name: a
+    do: {with: {path: echo, args: [a]}}
+  - name: b
+    depends-on: [a]
+    do: {with: {path: echo, args: [b]}}
+`
+	cases := map[string]string{
+		`reads task "a" in .tasks without depending on it`: "  - name: c\n    do: {with: {path: echo, args: [\"{{.tasks.a.outputs.version}}\"]}}\n",
+		`reads unknown task "aa" in .tasks`:                "  - name: c\n    depends-on: [b]\n    do: {with: {path: echo, args: [\"{{.tasks.aa.status}}\"]}}\n",
+		`reads task "b" in .tasks without depending on it`: "  - name: c\n    depends-on: [a]\n    cleanup: {with: {path: echo, args: ['{{index .tasks \"b\" \"status\"}}']}}\n",
+		"": "  - name: c\n    depends-on: [b]\n    do: {with: {path: echo, args: [\"{{.tasks.a.status}} {{index .tasks \\\"b\\\" \\\"status\\\"}}\"]}}\n",
+	}
+	for expected, task := range cases {
+		path := filepath.Join(t.TempDir(), "workflow.yaml")
+		os.WriteFile(path, []byte(tasks+task), 0644)
+		_, err := workflow.NewWorkflow(path)
+		if expected == "" {
+			if err != nil {
+				t.Errorf("Expected references to indirect dependencies to load, got %v", err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected an error containing %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "lib.yaml"), []byte(`tasks:
+  - name: one
+    do: {with: {path: echo, args: ["{{.undefined}}"]}}
+`), 0644)
+	path := filepath.Join(dir, "workflow.yaml")
+	os.WriteFile(path, []byte(`timout: 5m
+imports:
+  - file: lib.yaml
+    as: lib
+variables:
+  envs: [dev, prod]
+tasks:
+  - name: build
+    do:
+      with:
+        path: go
+        arg: [build]
+    retries: "3"
+  - name: build
+    do: {with: {path: echo, args: ["{{.version}}", "{{.tasks.build.status}}"]}}
+    depends-on: [missing, lib.one]
+  - name: "deploy-{{.env}}"
+    foreach:
+      - variable: envs
+        as: env
+      - variable: nothere
+        as: other
+    do: {this: shell, with: {script: "echo {{.env}"}}
+  - name: a
+    do: {this: nope}
+    trigger: sometimes
+    depends-on: [b, deploy-dev]
+  - name: b
+    do: {with: {path: echo}}
+    depends-on: [a]
+`), 0644)
+
+	lib := filepath.Join(dir, "lib.yaml")
+	expected := []string{
+		path + `:1:1: unknown key "timout" in workflow`,
+		path + `:12:9: unknown key "arg" in process action`,
+		path + `:13:14: retries must be an integer, got "3"`,
+		path + `:14:11: duplicate task name "build", first defined at line 8`,
+		path + `:15:36: undefined variable "version"`,
+		path + `:15:52: task "build" reads task "build" in .tasks without depending on it`,
+		path + `:16:18: task "build" depends on unknown task "missing"`,
+		path + `:21:19: foreach variable "nothere" is not defined`,
+		path + `:23:38: invalid template: bad character U+007D '}'`,
+		path + `:25:16: unknown action "nope": not registered and no gotasker-action-nope executable found`,
+		path + `:26:14: trigger: unknown value "sometimes" (use all-success, all-done, one-failed or always)`,
+		path + `:30:18: task "b" depends on "a", which already depends on it`,
+		lib + `:3:36: undefined variable "undefined"`,
+	}
+	var actual []string
+	opts := workflow.Options{CheckAction: engine.CheckAction, CheckCondition: engine.CheckCondition}
+	for _, problem := range workflow.Validate(path, opts) {
+		actual = append(actual, problem.String())
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Problems:\n%s\nexpected:\n%s", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestValidateTaskReferences(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workflow.yaml")
+	os.WriteFile(path, []byte(`tasks:
+  - name: a
+    do: {with: {path: echo}}
+  - name: b
+    depends-on: [a]
+    do: {with: {path: echo}}
+  - name: c
+    depends-on: [b]
+    do: {with: {path: echo, args: ["{{.tasks.a.status}}", "{{.tasks.aa.status}}"]}}
+  - name: d
+    depends-on: [a]
+    do: {with: {path: echo}}
+    cleanup: {with: {path: echo, args: ['{{index .tasks "c" "status"}}']}}
+`), 0644)
+
+	var messages []string
+	for _, problem := range workflow.Validate(path, workflow.Options{}) {
+		messages = append(messages, strings.TrimPrefix(problem.String(), path+":"))
+	}
+	expected := []string{
+		`9:59: task "c" reads unknown task "aa" in .tasks`,
+		`13:41: task "d" reads task "c" in .tasks without depending on it`,
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("Problems:\n%s\nexpected:\n%s", strings.Join(messages, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestValidateReadsValuesLikeTheLoader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workflow.yaml")
+	os.WriteFile(path, []byte(`strict: no
+tasks:
+  - name: a
+    retries: 1
+    retry-jitter: yes
+    do: {with: {path: echo, args: ["{{.undefined}}"]}}
+`), 0644)
+	if _, err := workflow.NewWorkflow(path); err != nil {
+		t.Fatalf("NewWorkflow error: %v", err)
+	}
+	if problems := workflow.Validate(path, workflow.Options{}); len(problems) > 0 {
+		t.Errorf("Expected the workflow run accepts to validate, got %v", problems)
+	}
+
+	os.WriteFile(path, []byte("strict: 'no'\ntasks: []\n"), 0644)
+	if _, err := workflow.NewWorkflow(path); err == nil {
+		t.Error("Expected a quoted strict to be rejected by NewWorkflow")
+	}
+	if problems := workflow.Validate(path, workflow.Options{}); len(problems) != 1 || problems[0].Message != `strict must be true or false, got "no"` {
+		t.Errorf("Expected a quoted strict to be reported, got %v", problems)
+	}
+}
+
+func TestValidateSyntaxErrors(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "workflow.json")
+	os.WriteFile(jsonPath, []byte("{\"tasks\": [\n  {\"name\": \"a\",, }\n]}"), 0644)
+	problems := workflow.Validate(jsonPath, workflow.Options{})
+	if len(problems) != 1 || problems[0].Line != 2 || problems[0].Column != 17 {
+		t.Errorf("Expected a syntax error at 2:17, got %v", problems)
+	}
+
+	yamlPath := filepath.Join(dir, "workflow.yaml")
+	os.WriteFile(yamlPath, []byte("- just\n- a list\n"), 0644)
+	problems = workflow.Validate(yamlPath, workflow.Options{})
+	if len(problems) != 1 || problems[0].String() != yamlPath+":1:1: the workflow must be a map, got a list" {
+		t.Errorf("Expected the workflow to be rejected as a list, got %v", problems)
+	}
+}
+
+func TestValidateChecksOfOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workflow.yaml")
+	os.WriteFile(path, []byte(`variables:
+  target: dev
+tasks:
+  - name: a
+    do: {this: nope}
+    when: 'target =='
+  - name: b
+    do: {this: process, with: {path: echo}}
+    when: '{{.target}} =='
+`), 0644)
+
+	if problems := workflow.Validate(path, workflow.Options{}); len(problems) > 0 {
+		t.Errorf("Without checks, expected no problems, got %v", problems)
+	}
+	opts := workflow.Options{CheckAction: engine.CheckAction, CheckCondition: engine.CheckCondition}
+	var actual []string
+	for _, problem := range workflow.Validate(path, opts) {
+		actual = append(actual, problem.String())
+	}
+	expected := []string{
+		path + `:5:16: unknown action "nope": not registered and no gotasker-action-nope executable found`,
+		path + `:6:11: when: unexpected end of expression`,
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Problems:\n%s\nexpected:\n%s", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestValidateExamples(t *testing.T) {
+	paths, _ := filepath.Glob(filepath.Join(getExamplesDir(), "*.*"))
+	for _, path := range paths {
+		if problems := workflow.Validate(path, workflow.Options{}); len(problems) > 0 {
+			t.Errorf("%s: unexpected problems %v", path, problems)
+		}
+	}
+}
+
+func TestNewWorkflowStrictTemplates(t *testing.T) {
+	content := `variables:
+  city: Paris
+tasks:
+  - name: "greet-{{.city}}"
+    do:
+      with:
+        path: echo
+        args: ["Hello from {{.cititi}}", "{{.city}}"]
+`
+	path := filepath.Join(t.TempDir(), "workflow.yaml")
+	os.WriteFile(path, []byte(content), 0644)
+	_, err := workflow.NewWorkflow(path)
+	if err == nil || !strings.Contains(err.Error(), `task greet-Paris: do.with.args[0]: template "Hello from {{.cititi}}"`) ||
+		!strings.Contains(err.Error(), `map has no entry for key "cititi"`) {
+		t.Fatalf("Expected an error naming the task, field and variable, got %v", err)
+	}
+
+	wf, err := workflow.NewWorkflowWithOptions(path, workflow.Options{Lenient: true})
+	if err != nil {
+		t.Fatalf("Lenient NewWorkflowWithOptions error: %v", err)
+	}
+	if arg := wf.Tasks[0].Do.With.Args[0]; arg != "Hello from <no value>" {
+		t.Errorf("Lenient arg: %v, expected Hello from <no value>", arg)
+	}
+
+	os.WriteFile(path, []byte("strict: false\n"+content), 0644)
+	if _, err := workflow.NewWorkflow(path); err != nil {
+		t.Errorf("A workflow opting out of strict mode should load, got %v", err)
+	}
+	if problems := workflow.Validate(path, workflow.Options{}); len(problems) > 0 {
+		t.Errorf("Undefined variables should not be problems in lenient workflows, got %v", problems)
+	}
+}
+
+func TestTemplateFunctions(t *testing.T) {
+	t.Setenv("GOTASKER_TEST_FUNC", "from-env")
+	file := filepath.Join(t.TempDir(), "content.txt")
+	os.WriteFile(file, []byte("file content"), 0644)
+	variables := map[string]interface{}{
+		"name":  "My App",
+		"names": []interface{}{"a", "b", "c"},
+		"empty": "",
+		"file":  file,
+	}
+	cases := map[string]string{
+		`{{ .name | upper }}`:                        "MY APP",
+		`{{ .name | lower | replace " " "-" }}`:      "my-app",
+		`{{ "  padded " | trim }}`:                   "padded",
+		`{{ split "," "x,y" | join "+" }}`:           "x+y",
+		`{{ .names | join "," }}`:                    "a,b,c",
+		`{{ .empty | default "fallback" }}`:          "fallback",
+		`{{ .name | default "fallback" }}`:           "My App",
+		`{{ env "GOTASKER_TEST_FUNC" }}`:             "from-env",
+		`{{ now | date "2006" | len }}`:              "4",
+		`{{ "abc" | sha256 }}`:                       "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		`{{ base64 "abc" }}`:                         "YWJj",
+		`{{ base64Decode "YWJj" }}`:                  "abc",
+		`{{ toJson .names }}`:                        `["a","b","c"]`,
+		`{{ (fromJson "{\"a\": {\"b\": 2}}").a.b }}`: "2",
+		`{{ joinPath "dir" "sub" "file.tar.gz" }}`:   "dir/sub/file.tar.gz",
+		`{{ base "dir/file.txt" }}`:                  "file.txt",
+		`{{ dir "dir/file.txt" }}`:                   "dir",
+		`{{ ext "dir/file.txt" }}`:                   ".txt",
+		`{{ clean "dir/../other/" }}`:                "other",
+		`{{ readFile .file }}`:                       "file content",
+		`{{ first .names }}-{{ last .names }}`:       "a-c",
+		`{{ index .names 1 }} {{ len .names }}`:      "b 3",
+	}
+	for template, expected := range cases {
+		if actual := workflow.ReplacePlaceholders(template, variables); actual != expected {
+			t.Errorf("%s: got %q, expected %q", template, actual, expected)
+		}
+	}
+}
+
+func TestProcessWorkflowRendersVariables(t *testing.T) {
+	input := map[string]interface{}{
+		"variables": map[string]interface{}{
+			"app":   "My App",
+			"image": "{{.slug}}:latest",
+			"slug":  `{{ .app | lower | replace " " "-" }}`,
+		},
+		"tasks": []interface{}{
+			map[string]interface{}{"name": "build-{{.image}}"},
+		},
+	}
+	tasks, err := workflow.ProcessWorkflow(input)
+	if err != nil {
+		t.Fatalf("ProcessWorkflow error: %v", err)
+	}
+	if name := tasks[0]["name"]; name != "build-my-app:latest" {
+		t.Errorf("Name: %v, expected build-my-app:latest", name)
+	}
+
+	input["variables"] = map[string]interface{}{"a": "{{.b}}", "b": "{{.a}}"}
+	if _, err := workflow.ProcessWorkflow(input); err == nil || !strings.Contains(err.Error(), "a -> b -> a") {
+		t.Errorf("Expected variables reading each other to be rejected, got %v", err)
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	cases := map[string]interface{}{
+		"3":           3,
+		"2.5":         2.5,
+		"true":        true,
+		"1.10":        "1.10",
+		"007":         "007",
+		"yes":         true,
+		"off":         false,
+		"'yes'":       "yes",
+		"hello":       "hello",
+		"a: b":        "a: b",
+		"":            "",
+		"[x, yes, 3]": []interface{}{"x", true, 3},
+		"[unclosed":   "[unclosed",
+	}
+	for input, expected := range cases {
+		if actual := workflow.ParseValue(input); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("ParseValue(%q) = %#v, expected %#v", input, actual, expected)
+		}
+	}
+}
+
+func TestResolveOverrides(t *testing.T) {
+	varFile := filepath.Join(t.TempDir(), "vars.yaml")
+	os.WriteFile(varFile, []byte("city: Lyon\nregion: Rhone\n"), 0644)
+	environ := []string{"GOTASKER_VAR_city=Rome", "GOTASKER_VAR_count=5", "GOTASKER_VAR_region=Lazio", "OTHER=1"}
+
+	opts, err := workflow.ResolveOverrides(environ, []string{varFile}, []string{"region=Auvergne", "names=[a, b]"})
+	if err != nil {
+		t.Fatalf("ResolveOverrides error: %v", err)
+	}
+	expected := map[string]interface{}{
+		"city":   "Lyon",
+		"count":  5,
+		"region": "Auvergne",
+		"names":  []interface{}{"a", "b"},
+	}
+	if !reflect.DeepEqual(opts.Variables, expected) {
+		t.Errorf("Variables: %v, expected %v", opts.Variables, expected)
+	}
+	sources := map[string]string{
+		"city":   "var file " + varFile,
+		"count":  "env GOTASKER_VAR_count",
+		"region": "-var",
+		"names":  "-var",
+	}
+	if !reflect.DeepEqual(opts.Sources, sources) {
+		t.Errorf("Sources: %v, expected %v", opts.Sources, sources)
+	}
+
+	if _, err := workflow.ResolveOverrides(nil, nil, []string{"novalue"}); err == nil {
+		t.Error("Expected an assignment without = to be rejected")
+	}
+	if _, err := workflow.ResolveOverrides(nil, []string{filepath.Join(t.TempDir(), "missing.yaml")}, nil); err == nil {
+		t.Error("Expected a missing var file to be rejected")
+	}
+}
+
+func TestResolveOverridesReadsValuesAlike(t *testing.T) {
+	varFile := filepath.Join(t.TempDir(), "vars.yaml")
+	os.WriteFile(varFile, []byte("fileYes: yes\nfileNo: no\nfileOn: on\nfileQuoted: 'yes'\nfileVersion: 1.10\nfileList: [on, off]\n"), 0644)
+	environ := []string{"GOTASKER_VAR_envYes=yes", "GOTASKER_VAR_envNo=no", "GOTASKER_VAR_envOn=on"}
+	assignments := []string{"flagYes=yes", "flagNo=no", "flagOn=on", "flagQuoted='yes'", "flagVersion=1.10", "flagList=[on, off]"}
+
+	opts, err := workflow.ResolveOverrides(environ, []string{varFile}, assignments)
+	if err != nil {
+		t.Fatalf("ResolveOverrides error: %v", err)
+	}
+	expected := map[string]interface{}{
+		"envYes": true, "envNo": false, "envOn": true,
+		"fileYes": true, "fileNo": false, "fileOn": true,
+		"flagYes": true, "flagNo": false, "flagOn": true,
+		"fileQuoted": "yes", "flagQuoted": "yes",
+		"fileVersion": "1.10", "flagVersion": "1.10",
+		"fileList": []interface{}{true, false}, "flagList": []interface{}{true, false},
+	}
+	if !reflect.DeepEqual(opts.Variables, expected) {
+		t.Errorf("Variables: %v, expected %v", opts.Variables, expected)
+	}
+}
+
+func TestNewWorkflowVariableSources(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "lib.yaml"), []byte("variables:\n  level: info\ntasks: []\n"), 0644)
+	path := filepath.Join(dir, "workflow.yaml")
+	os.WriteFile(path, []byte(`imports:
+  - file: lib.yaml
+    as: lib
+variables:
+  city: Paris
+  greeting: hello
+tasks:
+  - name: greet
+    do: {with: {path: echo, args: ["{{.greeting}} {{.city}}"]}}
+`), 0644)
+
+	opts, _ := workflow.ResolveOverrides(nil, nil, []string{"city=Rome"})
+	wf, err := workflow.NewWorkflowWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("NewWorkflowWithOptions error: %v", err)
+	}
+	if args := wf.Tasks[0].Do.With.Args; len(args) != 1 || args[0] != "hello Rome" {
+		t.Errorf("Args: %v, expected [hello Rome]", args)
+	}
+	expected := map[string]string{
+		"city":     "-var",
+		"greeting": workflow.SourceFile,
+		"level":    "import lib.yaml",
+	}
+	if !reflect.DeepEqual(wf.VariableSources, expected) {
+		t.Errorf("VariableSources: %v, expected %v", wf.VariableSources, expected)
+	}
+}
+
+func TestInputCheck(t *testing.T) {
+	tests := []struct {
+		input    workflow.Input
+		value    interface{}
+		expected interface{}
+		err      string
+	}{
+		{workflow.Input{Type: workflow.InputString}, 5, "5", ""},
+		{workflow.Input{Type: workflow.InputString}, []interface{}{"a"}, nil, "is not a string"},
+		{workflow.Input{Type: workflow.InputInt}, 3, 3, ""},
+		{workflow.Input{Type: workflow.InputInt}, 3.0, 3, ""},
+		{workflow.Input{Type: workflow.InputInt}, "42", 42, ""},
+		{workflow.Input{Type: workflow.InputInt}, 1.5, nil, "1.5 is not an int"},
+		{workflow.Input{Type: workflow.InputBool}, "true", true, ""},
+		{workflow.Input{Type: workflow.InputBool}, "maybe", nil, `"maybe" is not a bool`},
+		{workflow.Input{Type: workflow.InputList}, []interface{}{"a", "b"}, []interface{}{"a", "b"}, ""},
+		{workflow.Input{Type: workflow.InputList}, "a", nil, "a is not a list"},
+		{workflow.Input{Type: workflow.InputEnum, Values: []interface{}{"dev", 2}}, "2", 2, ""},
+		{workflow.Input{Type: workflow.InputEnum, Values: []interface{}{"dev", "prod"}}, "qa", nil, "qa is not one of dev|prod"},
+		{workflow.Input{Type: workflow.InputString, Pattern: `^v\d+$`}, "v1", "v1", ""},
+		{workflow.Input{Type: workflow.InputString, Pattern: `^v\d+$`}, "1", nil, `"1" does not match ^v\d+$`},
+		{workflow.Input{Type: workflow.InputList, Pattern: `^[a-z]+$`}, []interface{}{"api", "Web"}, nil, `"Web" does not match`},
+	}
+	for _, test := range tests {
+		value, err := test.input.Check(test.value)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Check(%v) of a %s: expected an error containing %q, got %v", test.value, test.input.Type, test.err, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(value, test.expected) {
+			t.Errorf("Check(%v) of a %s: %#v, %v, expected %#v", test.value, test.input.Type, value, err, test.expected)
+		}
+	}
+}
+
+const inputsWorkflow = `description: Deploys the services.
+inputs:
+  environment:
+    type: enum
+    values: [dev, prod]
+    description: Target environment
+    required: true
+  replicas:
+    type: int
+    default: 2
+  tag:
+    pattern: '^v\d+$'
+  services:
+    type: list
+    default: [api]
+variables:
+  target: "{{.environment}}-cluster"
+tasks:
+  - name: deploy-{{.service}}
+    foreach:
+      - variable: services
+        as: service
+    do: {with: {path: echo, args: ["{{.target}}", "{{.replicas}}", "{{.tag}}"]}}
+`
+
+func TestNewWorkflowInputs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workflow.yaml")
+	os.WriteFile(path, []byte(inputsWorkflow), 0644)
+
+	_, err := workflow.NewWorkflow(path)
+	if err == nil || !strings.Contains(err.Error(), "input environment is required: set it with -var environment=<dev|prod>") {
+		t.Errorf("Expected a missing required input to be rejected, got %v", err)
+	}
+
+	opts, _ := workflow.ResolveOverrides(nil, nil, []string{"environment=qa", "replicas=many", "tag=1"})
+	_, err = workflow.NewWorkflowWithOptions(path, opts)
+	for _, expected := range []string{
+		"input environment: qa is not one of dev|prod",
+		`input replicas: "many" is not an int`,
+		`input tag: "1" does not match ^v\d+$`,
+	} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected an error containing %q, got %v", expected, err)
+		}
+	}
+
+	opts, _ = workflow.ResolveOverrides([]string{"GOTASKER_VAR_replicas=3"}, nil, []string{"environment=prod", "services=[api, web]"})
+	wf, err := workflow.NewWorkflowWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("NewWorkflowWithOptions error: %v", err)
+	}
+	if len(wf.Tasks) != 2 || wf.Tasks[1].Name != "deploy-web" {
+		t.Fatalf("Tasks: %v, expected deploy-api and deploy-web", wf.Tasks)
+	}
+	if args := wf.Tasks[0].Do.With.Args; !reflect.DeepEqual(args, []interface{}{"prod-cluster", "3", ""}) {
+		t.Errorf("Args: %v, expected [prod-cluster 3 ]", args)
+	}
+	if source := wf.VariableSources["tag"]; source != workflow.SourceInputDefault {
+		t.Errorf("Source of tag: %q, expected %q", source, workflow.SourceInputDefault)
+	}
+
+	description, inputs, err := workflow.ReadInputs(path)
+	if err != nil {
+		t.Fatalf("ReadInputs error: %v", err)
+	}
+	var names []string
+	for _, input := range inputs {
+		names = append(names, input.Name+" "+input.Usage())
+	}
+	expected := []string{"environment dev|prod", "replicas int", "services list", "tag string"}
+	if description != "Deploys the services." || !reflect.DeepEqual(names, expected) {
+		t.Errorf("ReadInputs: %q %v, expected the description and %v", description, names, expected)
+	}
+}
+
+func TestValidateInputs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workflow.yaml")
+	os.WriteFile(path, []byte(`variables:
+  region: eu
+inputs:
+  region: {}
+  size:
+    type: number
+  level:
+    type: enum
+  count:
+    type: int
+    default: many
+  name:
+    pattern: "("
+  replicas:
+    type: int
+tasks:
+  - name: "scale-{{.replicas}}"
+    do: {with: {path: echo, args: ["{{.level}}"]}}
+`), 0644)
+
+	var messages []string
+	for _, problem := range workflow.Validate(path, workflow.Options{Variables: map[string]interface{}{"replicas": "lots"}}) {
+		messages = append(messages, strings.TrimPrefix(problem.String(), path+":"))
+	}
+	expected := []string{
+		`4:3: input "region" is also a variable`,
+		`6:11: type: unknown value "number" (use string, int, bool, list or enum)`,
+		`8:5: input level: an enum needs values`,
+		`10:5: input count: default: "many" is not an int`,
+		"13:14: pattern: error parsing regexp: missing closing ): `(`",
+		`14:3: input replicas: "lots" is not an int`,
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("Problems:\n%s\nexpected:\n%s", strings.Join(messages, "\n"), strings.Join(expected, "\n"))
+	}
+}